@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"ibenc/config"
+	"ibenc/fleet"
+)
+
+// startAgent registers the daemon with the fleet controller, applies its first assignment
+// before any test runs and keeps reporting in the background
+func (d *daemon) startAgent(ctx context.Context, cfg *config.Config, requestReload func()) {
+	id := cfg.Controller.ID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	d.agent = fleet.NewAgent(cfg.Controller.URL, cfg.Controller.Token, id)
+
+	assignment, err := d.agent.Heartbeat(d.heartbeat())
+	if err != nil {
+		log.Printf("Warning: controller unreachable, starting with the local configuration: %v", err)
+	} else if d.applyAssignment(assignment) {
+		d.reload()
+	}
+
+	go d.agent.Run(ctx, d.heartbeat, func(assignment *fleet.Assignment) {
+		if d.applyAssignment(assignment) {
+			requestReload()
+		}
+	})
+	log.Printf("Reporting to controller %s as %s\n", cfg.Controller.URL, id)
+}
+
+// applyAssignment stores the configuration and schedule pushed by the controller
+// and reports whether either changed
+func (d *daemon) applyAssignment(assignment *fleet.Assignment) bool {
+	changed := d.offset.Swap(int64(assignment.OffsetSeconds)) != int64(assignment.OffsetSeconds)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if assignment.ConfigVersion != d.configVersion {
+		log.Printf("Controller pushed configuration %s\n", assignment.ConfigVersion)
+		d.configVersion = assignment.ConfigVersion
+		d.overlays = make([][]byte, 0, len(assignment.Overlays))
+		for _, overlay := range assignment.Overlays {
+			d.overlays = append(d.overlays, []byte(overlay))
+		}
+		changed = true
+	}
+
+	return changed
+}
+
+// heartbeat describes the daemon to the controller
+func (d *daemon) heartbeat() fleet.Heartbeat {
+	cfg := d.config.Load()
+	targets := cfg.EffectiveTargets()
+	hostname, _ := os.Hostname()
+
+	var servers []string
+	for _, target := range targets {
+		servers = append(servers, target.ServerList()...)
+	}
+
+	return fleet.Heartbeat{
+		Hostname:        hostname,
+		Version:         version,
+		Servers:         servers,
+		IntervalSeconds: int(interval(cfg, targets[0]).Seconds()),
+	}
+}
+
+// reportResults sends the outcome of each test to the controller
+func (d *daemon) reportResults(runs []targetRun) {
+	for _, run := range runs {
+		result := fleet.Result{
+			Target:    run.target.Name,
+			IPVersion: run.target.IPVersion(),
+			Time:      run.time,
+			Success:   run.err == nil,
+		}
+		if run.err != nil {
+			result.Error = run.err.Error()
+		}
+		if run.result != nil {
+			result.DownloadMbps = run.result.DownloadMbps
+			result.UploadMbps = run.result.UploadMbps
+			result.LatencyMs = run.result.LatencyMs
+			result.JitterMs = run.result.JitterMs
+			result.PacketLossPercent = run.result.PacketLossPercent
+		}
+
+		if err := d.agent.SendResult(result); err != nil {
+			log.Printf("Warning: failed to report result to controller: %v", err)
+		}
+	}
+}
+
+// alignSchedule places targets that haven't run yet on the next slot of the controller's stagger,
+// slots are offset into every interval so agents sharing a server take turns
+func alignSchedule(cfg *config.Config, lastRun map[string]time.Time, offset time.Duration) {
+	now := time.Now()
+	for _, target := range cfg.EffectiveTargets() {
+		if _, ok := lastRun[target.Name]; ok {
+			continue
+		}
+
+		every := interval(cfg, target)
+		slot := now.Truncate(every).Add(offset % every)
+		for !slot.After(now) {
+			slot = slot.Add(every)
+		}
+		lastRun[target.Name] = slot.Add(-every)
+	}
+}
@@ -0,0 +1,224 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ibenc/iperf3"
+)
+
+// Rule fires when a test result field crosses a threshold for consecutive runs
+type Rule struct {
+	Name      string
+	Field     string // download_mbps, upload_mbps, latency_ms, jitter_ms or packet_loss_percent
+	Op        string // <, <=, > or >=
+	Threshold float64
+	For       int // consecutive breaching runs before firing, 0 or 1 fires immediately
+}
+
+// Config holds the rules, webhooks and notification limits
+type Config struct {
+	Rules          []Rule
+	Webhooks       []Webhook
+	MinInterval    time.Duration     // minimum time between firing notifications of a rule
+	RepeatInterval time.Duration     // resend firing notifications this often, 0 disables repeats
+	StatePath      string            // file keeping rule state between runs
+	Labels         map[string]string // labels included in every notification
+}
+
+// ruleState is the persisted state of a single rule
+type ruleState struct {
+	Breaches     int       `json:"breaches"`
+	Firing       bool      `json:"firing"`
+	Notified     bool      `json:"notified"`
+	LastNotified time.Time `json:"last_notified"`
+}
+
+// Manager evaluates rules and sends notifications
+type Manager struct {
+	config Config
+	state  map[string]*ruleState
+}
+
+// NewManager creates an alert manager and loads the rule state from disk
+func NewManager(config Config) (*Manager, error) {
+	m := &Manager{
+		config: config,
+		state:  make(map[string]*ruleState),
+	}
+
+	data, err := os.ReadFile(config.StatePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read alert state: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m.state); err != nil {
+			return nil, fmt.Errorf("failed to parse alert state: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Evaluate checks every rule against the result, sends notifications and saves state
+// A nil result stands for a failed run, throughput rules see zero and other rules keep their state
+func (m *Manager) Evaluate(result *iperf3.TestResult) error {
+	now := time.Now()
+
+	failed := result == nil
+	if failed {
+		result = &iperf3.TestResult{}
+	}
+
+	for _, rule := range m.config.Rules {
+		// A failed run measured no latency, jitter or loss, zeros would resolve those rules
+		if failed && !isThroughputField(rule.Field) {
+			continue
+		}
+
+		st, ok := m.state[rule.Name]
+		if !ok {
+			st = &ruleState{}
+			m.state[rule.Name] = st
+		}
+
+		value := FieldValue(result, rule.Field)
+		if breached(value, rule.Op, rule.Threshold) {
+			st.Breaches++
+		} else {
+			st.Breaches = 0
+		}
+
+		firing := st.Breaches >= max(rule.For, 1)
+		switch {
+		case firing && !st.Notified:
+			// Firing but not announced yet, rate limited so a flapping rule doesn't flood the webhooks
+			// A suppressed or undelivered notification stays pending and is retried on the next run
+			st.Firing = true
+			if now.Sub(st.LastNotified) < m.config.MinInterval {
+				log.Printf("Alert %s firing, notification pending until the rate limit allows it", rule.Name)
+			} else if m.notify(Notification{Status: StatusFiring, Rule: rule, Value: value}, st, now) {
+				st.Notified = true
+			}
+		case firing:
+			// Still firing, remind if repeats are enabled
+			st.Firing = true
+			if m.config.RepeatInterval > 0 && now.Sub(st.LastNotified) >= m.config.RepeatInterval {
+				m.notify(Notification{Status: StatusFiring, Rule: rule, Value: value}, st, now)
+			}
+		case st.Notified:
+			// Resolved after an announced firing, retried on later runs until a webhook takes it
+			st.Firing = false
+			if m.notify(Notification{Status: StatusResolved, Rule: rule, Value: value}, st, now) {
+				st.Notified = false
+			}
+		default:
+			st.Firing = false
+		}
+	}
+
+	// Forget rules that were removed from the configuration
+	for name := range m.state {
+		if !m.hasRule(name) {
+			delete(m.state, name)
+		}
+	}
+
+	return m.save()
+}
+
+// notify sends a notification to every webhook and reports whether any of them received it
+func (m *Manager) notify(n Notification, st *ruleState, now time.Time) bool {
+	n.Labels = m.config.Labels
+	n.Time = now
+
+	log.Printf("Alert %s %s (%s = %.2f, threshold %s %.2f)", n.Rule.Name, n.Status, n.Rule.Field, n.Value, n.Rule.Op, n.Rule.Threshold)
+
+	delivered := len(m.config.Webhooks) == 0
+	for _, webhook := range m.config.Webhooks {
+		if err := webhook.Send(n); err != nil {
+			log.Printf("Warning: failed to send alert to webhook %s: %v", webhook.Name, err)
+			continue
+		}
+		delivered = true
+	}
+
+	if delivered {
+		st.LastNotified = now
+	}
+	return delivered
+}
+
+// hasRule reports whether a rule with the given name is configured
+func (m *Manager) hasRule(name string) bool {
+	for _, rule := range m.config.Rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the rule state to disk atomically
+func (m *Manager) save() error {
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode alert state: %w", err)
+	}
+
+	tmp := m.config.StatePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(m.config.StatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write alert state: %w", err)
+	}
+	if err := os.Rename(tmp, m.config.StatePath); err != nil {
+		return fmt.Errorf("failed to write alert state: %w", err)
+	}
+
+	return nil
+}
+
+// FieldValue returns the value of a test result field by its rule name
+func FieldValue(result *iperf3.TestResult, field string) float64 {
+	switch field {
+	case "download_mbps":
+		return result.DownloadMbps
+	case "upload_mbps":
+		return result.UploadMbps
+	case "latency_ms":
+		return result.LatencyMs
+	case "jitter_ms":
+		return result.JitterMs
+	case "packet_loss_percent":
+		return result.PacketLossPercent
+	default:
+		return 0
+	}
+}
+
+// isThroughputField reports whether a field holds a throughput, which is zero for a failed run
+func isThroughputField(field string) bool {
+	return field == "download_mbps" || field == "upload_mbps"
+}
+
+// breached compares a value with a threshold
+func breached(value float64, op string, threshold float64) bool {
+	switch op {
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	default:
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Notification describes a rule changing state
+type Notification struct {
+	Status string
+	Rule   Rule
+	Value  float64
+	Labels map[string]string
+	Time   time.Time
+}
+
+// Summary returns a short human readable description of the notification
+func (n Notification) Summary() string {
+	var labels []string
+	for name, value := range n.Labels {
+		if value != "" {
+			labels = append(labels, name+"="+value)
+		}
+	}
+	sort.Strings(labels)
+
+	status := "FIRING"
+	if n.Status == StatusResolved {
+		status = "RESOLVED"
+	}
+
+	summary := fmt.Sprintf("[%s] %s: %s is %.2f (threshold %s %.2f)",
+		status, n.Rule.Name, n.Rule.Field, n.Value, n.Rule.Op, n.Rule.Threshold)
+	if len(labels) > 0 {
+		summary += " " + strings.Join(labels, ", ")
+	}
+	return summary
+}
+
+// Webhook is an HTTP endpoint receiving notifications
+type Webhook struct {
+	Name     string
+	URL      string
+	Format   string // json (default), slack, discord or teams
+	Template string // optional text/template for the request body, overrides Format
+}
+
+// Send posts the notification to the webhook
+func (w Webhook) Send(n Notification) error {
+	body, err := w.payload(n)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// payload renders the request body for the webhook format
+func (w Webhook) payload(n Notification) ([]byte, error) {
+	if w.Template != "" {
+		tmpl, err := template.New(w.Name).Parse(w.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %w", err)
+		}
+		buffer := &bytes.Buffer{}
+		if err := tmpl.Execute(buffer, n); err != nil {
+			return nil, fmt.Errorf("failed to render webhook template: %w", err)
+		}
+		return buffer.Bytes(), nil
+	}
+
+	var payload any
+	switch w.Format {
+	case "slack":
+		payload = map[string]any{"text": n.Summary()}
+	case "discord":
+		payload = map[string]any{"content": n.Summary()}
+	case "teams":
+		color := "D9534F"
+		if n.Status == StatusResolved {
+			color = "5CB85C"
+		}
+		payload = map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"summary":    n.Rule.Name,
+			"themeColor": color,
+			"title":      "ibenc alert " + n.Rule.Name,
+			"text":       n.Summary(),
+		}
+	default:
+		payload = map[string]any{
+			"status":    n.Status,
+			"rule":      n.Rule.Name,
+			"field":     n.Rule.Field,
+			"op":        n.Rule.Op,
+			"threshold": n.Rule.Threshold,
+			"value":     n.Value,
+			"labels":    n.Labels,
+			"timestamp": n.Time.UTC().Format(time.RFC3339),
+			"summary":   n.Summary(),
+		}
+	}
+
+	// Keep operators like < readable in chat messages
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
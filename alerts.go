@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+
+	"ibenc/alert"
+	"ibenc/config"
+	"ibenc/iperf3"
+)
+
+// evaluateAlerts runs the local alert rules against a test result
+// Every target keeps its own rule state, a failed test is passed as a nil result
+func evaluateAlerts(cfg *config.Config, target config.TargetConfig, result *iperf3.TestResult) {
+	if len(cfg.Alerts.Rules) == 0 {
+		return
+	}
+
+	rules := make([]alert.Rule, 0, len(cfg.Alerts.Rules))
+	for _, r := range cfg.Alerts.Rules {
+		rules = append(rules, alert.Rule{
+			Name:      r.Name,
+			Field:     r.Field,
+			Op:        r.Op,
+			Threshold: r.Threshold,
+			For:       r.For,
+		})
+	}
+
+	webhooks := make([]alert.Webhook, 0, len(cfg.Alerts.Webhooks))
+	for _, w := range cfg.Alerts.Webhooks {
+		webhooks = append(webhooks, alert.Webhook{
+			Name:     w.Name,
+			URL:      w.URL,
+			Format:   w.Format,
+			Template: w.Template,
+		})
+	}
+
+	manager, err := alert.NewManager(alert.Config{
+		Rules:          rules,
+		Webhooks:       webhooks,
+		MinInterval:    cfg.Alerts.MinInterval,
+		RepeatInterval: cfg.Alerts.RepeatInterval,
+		StatePath:      cfg.StatePath(targetStateFile("alerts", target)),
+		Labels:         cfg.GetMetricsLabels(target),
+	})
+	if err != nil {
+		log.Printf("Warning: alerting disabled: %v", err)
+		return
+	}
+
+	if err := manager.Evaluate(result); err != nil {
+		log.Printf("Warning: failed to evaluate alerts: %v", err)
+	}
+}
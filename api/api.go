@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"ibenc/iperf3"
+)
+
+// maxTests is the number of finished tests kept for GET /v1/tests/{id}
+const maxTests = 50
+
+// finalEventTimeout is how long streaming clients that fell behind get to take the result event
+const finalEventTimeout = 5 * time.Second
+
+// Request starts a test, empty fields keep the configured value of the target
+type Request struct {
+	Target   string `json:"target,omitempty"`
+	Server   string `json:"server,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	Parallel int    `json:"parallel,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Bitrate  string `json:"bitrate,omitempty"`
+}
+
+// Result is the outcome of a test over one address family
+type Result struct {
+	RunID             string  `json:"run_id"`
+	Target            string  `json:"target,omitempty"`
+	IPVersion         string  `json:"ip_version,omitempty"`
+	Success           bool    `json:"success"`
+	Error             string  `json:"error,omitempty"`
+	DownloadMbps      float64 `json:"download_mbps"`
+	UploadMbps        float64 `json:"upload_mbps"`
+	LatencyMs         float64 `json:"latency_ms"`
+	JitterMs          float64 `json:"jitter_ms"`
+	PacketLossPercent float64 `json:"packet_loss_percent"`
+}
+
+// Test is a test started through the API
+type Test struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"` // queued, running, done or failed
+	Request  Request    `json:"request"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Results  []Result   `json:"results,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Runner checks a request and prepares the test, errors are reported to the client as bad requests
+type Runner func(req Request) (Run, error)
+
+// Run executes a prepared test, started is called once it has the link and onInterval with its progress
+type Run func(started func(), onInterval func(iperf3.Interval)) ([]Result, error)
+
+// event is a server-sent event of a test
+type event struct {
+	name string
+	data []byte
+}
+
+// test is the state of a test with the events streamed to clients
+type test struct {
+	Test
+	events      []event
+	subscribers map[chan event]bool
+}
+
+// Server serves the on-demand test API, one test at a time
+type Server struct {
+	token  string // bearer token clients must send
+	runner Runner
+
+	mu     sync.Mutex
+	tests  map[string]*test
+	order  []string // test IDs, oldest first
+	active *test
+}
+
+// NewServer creates an API server
+func NewServer(token string, runner Runner) *Server {
+	return &Server{token: token, runner: runner, tests: make(map[string]*test)}
+}
+
+// Handler returns the HTTP API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/tests", Authorized(s.token, s.handleStart))
+	mux.HandleFunc("GET /v1/tests/{id}", Authorized(s.token, s.handleGet))
+	mux.HandleFunc("GET /v1/tests/{id}/stream", Authorized(s.token, s.handleStream))
+	return mux
+}
+
+// Authorized rejects requests without the bearer token, every request when the token is empty
+func Authorized(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + token
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleStart queues a test and returns it with 202, or the running test with 409
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	run, err := s.runner(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	if s.active != nil {
+		id := s.active.ID
+		s.mu.Unlock()
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a test is already running", "id": id})
+		return
+	}
+
+	t := &test{
+		Test:        Test{ID: newID(), Status: "queued", Request: req, Created: time.Now()},
+		subscribers: make(map[chan event]bool),
+	}
+	s.tests[t.ID] = t
+	s.order = append(s.order, t.ID)
+	if len(s.order) > maxTests {
+		delete(s.tests, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.active = t
+	snapshot := t.Test
+	s.mu.Unlock()
+
+	go s.execute(t, run)
+
+	w.Header().Set("Location", "/v1/tests/"+t.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// execute runs a test and publishes its progress
+func (s *Server) execute(t *test, run Run) {
+	started := func() {
+		s.mu.Lock()
+		now := time.Now()
+		t.Status, t.Started = "running", &now
+		s.mu.Unlock()
+		s.publish(t, "status", map[string]string{"status": "running"})
+	}
+	onInterval := func(interval iperf3.Interval) {
+		s.publish(t, "interval", interval)
+	}
+
+	results, err := run(started, onInterval)
+
+	s.mu.Lock()
+	now := time.Now()
+	t.Finished, t.Results, t.Status = &now, results, "done"
+	if err != nil {
+		t.Status, t.Error = "failed", err.Error()
+	}
+	s.active = nil
+	final := t.Test
+	s.mu.Unlock()
+
+	s.finish(t, final)
+}
+
+// finish publishes the final result and ends the streams
+// Unlike intervals the result isn't dropped when a client falls behind, it gets finalEventTimeout to catch up
+func (s *Server) finish(t *test, final Test) {
+	data, err := json.Marshal(final)
+	if err != nil {
+		return
+	}
+	e := event{name: "result", data: data}
+
+	s.mu.Lock()
+	t.events = append(t.events, e)
+	subscribers := t.subscribers
+	t.subscribers = nil
+	s.mu.Unlock()
+
+	// Sent without holding the lock, a disconnecting client takes it to unsubscribe
+	ctx, cancel := context.WithTimeout(context.Background(), finalEventTimeout)
+	defer cancel()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+		}
+		// Closing the subscription ends the stream
+		close(ch)
+	}
+}
+
+// publish records an event of a test and sends it to the streaming clients
+func (s *Server) publish(t *test, name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	e := event{name: name, data: data}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.events = append(t.events, e)
+	for ch := range t.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// A client that can't keep up misses intervals, finish still delivers the result
+		}
+	}
+}
+
+// handleGet returns the status and results of a test
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	t := s.tests[r.PathValue("id")]
+	var snapshot Test
+	if t != nil {
+		snapshot = t.Test
+	}
+	s.mu.Unlock()
+
+	if t == nil {
+		writeError(w, http.StatusNotFound, "test not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleStream sends the events of a test as server-sent events, starting with the ones already published
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	s.mu.Lock()
+	t := s.tests[r.PathValue("id")]
+	if t == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "test not found")
+		return
+	}
+	history := append([]event(nil), t.events...)
+	var ch chan event
+	if t.subscribers != nil {
+		ch = make(chan event, 64)
+		t.subscribers[ch] = true
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range history {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+	if ch == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			s.mu.Lock()
+			delete(t.subscribers, ch)
+			s.mu.Unlock()
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a server-sent event
+func writeEvent(w io.Writer, e event) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// newID returns a random test ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
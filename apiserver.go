@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"ibenc/api"
+	"ibenc/config"
+	"ibenc/history"
+	"ibenc/iperf3"
+	"ibenc/web"
+)
+
+// Limits of on-demand test requests, targets configured with more allow up to their own values
+const (
+	maxRequestDuration = 60
+	maxRequestParallel = 8
+)
+
+// testJob is an on-demand test handed to the daemon loop, which runs it between scheduled tests
+type testJob struct {
+	target     config.TargetConfig
+	started    func()
+	onInterval func(iperf3.Interval)
+	done       chan []targetRun
+}
+
+// startAPI serves the on-demand test API, and the web UI with api.ui, until the context is cancelled
+func (d *daemon) startAPI(ctx context.Context, cfg *config.Config) {
+	handler := api.NewServer(cfg.API.Token, d.prepareTest).Handler()
+	if cfg.API.UI {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/tests", handler)
+		mux.Handle("/v1/tests/", handler)
+		mux.Handle("/", web.Handler(cfg.API.Token, web.Source{
+			History: func(since time.Time, target string) ([]history.Record, error) {
+				return historyStore(d.config.Load()).Query(since, target)
+			},
+			Config: func() ([]byte, error) {
+				return yaml.Marshal(d.config.Load().Redacted())
+			},
+			Run: func(id string) ([]byte, error) {
+				return rawStore(d.config.Load()).Load(id)
+			},
+		}))
+		handler = mux
+	}
+
+	server := &http.Server{
+		Addr:              cfg.API.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if cfg.API.UI {
+			log.Printf("Web UI at http://%s/\n", cfg.API.Listen)
+		}
+		log.Printf("Test API listening on %s\n", cfg.API.Listen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Warning: test API stopped: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
+
+// prepareTest checks an API request against the current configuration and returns a run that
+// queues it for the daemon loop, so it shares the run lock, budget and sinks of scheduled tests
+func (d *daemon) prepareTest(req api.Request) (api.Run, error) {
+	target, err := requestTarget(d.config.Load(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(started func(), onInterval func(iperf3.Interval)) ([]api.Result, error) {
+		job := testJob{target: target, started: started, onInterval: onInterval, done: make(chan []targetRun, 1)}
+		d.tests <- job
+		runs := <-job.done
+
+		var results []api.Result
+		var lastErr error
+		for _, run := range runs {
+			result := api.Result{RunID: run.id, Target: run.target.Name, IPVersion: run.target.IPVersion(), Success: run.err == nil}
+			if run.err != nil {
+				result.Error = run.err.Error()
+				lastErr = run.err
+			}
+			if run.result != nil {
+				result.DownloadMbps = run.result.DownloadMbps
+				result.UploadMbps = run.result.UploadMbps
+				result.LatencyMs = run.result.LatencyMs
+				result.JitterMs = run.result.JitterMs
+				result.PacketLossPercent = run.result.PacketLossPercent
+			}
+			results = append(results, result)
+		}
+		return results, lastErr
+	}, nil
+}
+
+// requestTarget returns the requested target, or the first one, with the request's overrides applied
+func requestTarget(cfg *config.Config, req api.Request) (config.TargetConfig, error) {
+	targets := cfg.EffectiveTargets()
+	target := targets[0]
+	if req.Target != "" {
+		found := false
+		for _, t := range targets {
+			if t.Name == req.Target {
+				target, found = t, true
+			}
+		}
+		if !found {
+			return config.TargetConfig{}, fmt.Errorf("target %s is not configured", req.Target)
+		}
+	}
+
+	// Callers may ask for up to the configured values or the fixed limits, whichever is larger
+	maxDuration := max(target.Duration, maxRequestDuration)
+	maxParallel := max(target.Parallel, maxRequestParallel)
+
+	switch {
+	case req.Port < 0 || req.Port > 65535:
+		return config.TargetConfig{}, fmt.Errorf("port must be between 1 and 65535")
+	case req.Duration < 0 || req.Duration > maxDuration:
+		return config.TargetConfig{}, fmt.Errorf("duration must be between 1 and %d", maxDuration)
+	case req.Parallel < 0 || req.Parallel > maxParallel:
+		return config.TargetConfig{}, fmt.Errorf("parallel must be between 1 and %d", maxParallel)
+	case req.Protocol != "" && req.Protocol != "tcp" && req.Protocol != "udp":
+		return config.TargetConfig{}, fmt.Errorf("protocol must be tcp or udp")
+	}
+
+	if req.Server != "" {
+		target.Server, target.Servers = req.Server, nil
+	}
+	if req.Port != 0 {
+		target.Port = req.Port
+	}
+	if req.Duration != 0 {
+		target.Duration = req.Duration
+	}
+	if req.Parallel != 0 {
+		target.Parallel = req.Parallel
+	}
+	if req.Protocol != "" {
+		target.Protocol = req.Protocol
+	}
+	if req.Bitrate != "" {
+		target.Bitrate = req.Bitrate
+	}
+
+	return target, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"ibenc/catalog"
+	"ibenc/config"
+	"ibenc/iperf3"
+)
+
+// autoServer in server or servers stands for the servers picked from the catalog
+const autoServer = "auto"
+
+// probeTimeout bounds a single TCP connect while probing catalog servers
+const probeTimeout = 2 * time.Second
+
+// endpoint is an iperf3 server address to test against
+type endpoint struct {
+	host string
+	port int
+}
+
+// usesAutoServer reports whether a target selects servers from the catalog
+func usesAutoServer(target config.TargetConfig) bool {
+	for _, server := range target.ServerList() {
+		if server == autoServer {
+			return true
+		}
+	}
+	return false
+}
+
+// targetEndpoints returns the servers of a target in the order they are tried,
+// with auto replaced by the servers selected from the catalog
+func targetEndpoints(cfg *config.Config, target config.TargetConfig) []endpoint {
+	var endpoints []endpoint
+	for _, server := range target.ServerList() {
+		if server != autoServer {
+			endpoints = append(endpoints, endpoint{host: server, port: target.Port})
+			continue
+		}
+
+		selection := currentSelection(cfg, target)
+		if selection == nil {
+			continue
+		}
+		for _, s := range selection.Servers {
+			endpoints = append(endpoints, endpoint{host: s.Host, port: s.Port()})
+		}
+	}
+	return endpoints
+}
+
+// currentSelection returns the cached server selection of a target, selecting again once it expired
+func currentSelection(cfg *config.Config, target config.TargetConfig) *catalog.Selection {
+	path := cfg.StatePath(targetStateFile("servers", target))
+	selection, err := catalog.LoadSelection(path)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if selection.Valid(cfg.ServerSelection.TTL) {
+		return selection
+	}
+
+	selection, err = selectServers(cfg, target)
+	if err != nil {
+		log.Printf("Warning: automatic server selection failed: %v", err)
+		return nil
+	}
+	if err := selection.Save(path); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return selection
+}
+
+// selectServers probes the catalog servers nearest to the configured location and keeps the fastest
+func selectServers(cfg *config.Config, target config.TargetConfig) (*catalog.Selection, error) {
+	sel := cfg.ServerSelection
+	servers, err := catalog.Load(sel.Catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a location every catalog server is a candidate
+	if sel.Latitude != 0 || sel.Longitude != 0 {
+		servers = catalog.Nearest(servers, sel.Latitude, sel.Longitude, sel.Candidates)
+	}
+
+	log.Printf("Selecting iperf3 servers, probing %d candidates\n", len(servers))
+	opts := iperf3.Options{
+		AddressFamily: target.AddressFamily,
+		BindAddress:   target.BindAddress,
+		Interface:     target.Interface,
+	}
+	selection := catalog.Select(catalog.Probe(servers, opts.Dialer(probeTimeout), opts.Network()), sel.Count)
+	if len(selection.Servers) == 0 {
+		return nil, fmt.Errorf("none of the %d candidates is reachable", len(servers))
+	}
+
+	names := make([]string, 0, len(selection.Servers))
+	for _, s := range selection.Servers {
+		names = append(names, s.Host)
+	}
+	log.Printf("Selected iperf3 servers: %s\n", strings.Join(names, ", "))
+
+	return selection, nil
+}
+
+// reviewSelection compares a result with the speed seen right after selection
+// and expires the selection when the servers degraded, so the next run selects again
+func reviewSelection(cfg *config.Config, target config.TargetConfig, result *iperf3.TestResult, ok bool) {
+	path := cfg.StatePath(targetStateFile("servers", target))
+	selection, err := catalog.LoadSelection(path)
+	if err != nil || selection == nil {
+		return
+	}
+
+	mbps := result.DownloadMbps
+	switch {
+	case !ok:
+		log.Println("Selected servers failed, selecting again on the next run")
+		err = os.Remove(path)
+	case selection.BaselineMbps == 0:
+		selection.BaselineMbps = mbps
+		err = selection.Save(path)
+	case mbps < selection.BaselineMbps*cfg.ServerSelection.DegradePercent/100:
+		log.Printf("Download dropped from %.2f to %.2f Mbps, selecting servers again on the next run\n", selection.BaselineMbps, mbps)
+		err = os.Remove(path)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to update server selection: %v", err)
+	}
+}
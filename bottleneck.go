@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/config"
+	"ibenc/iperf3"
+	"ibenc/metrics"
+	"ibenc/netif"
+)
+
+// wifiEfficiency is the share of the Wi-Fi bitrate a TCP test can reach,
+// protocol overhead and airtime sharing take the rest
+const wifiEfficiency = 0.6
+
+// detectClientLimits checks whether the measuring host, rather than the line, capped a test result
+// The reasons describe every limit that was reached
+func detectClientLimits(cfg *config.Config, target config.TargetConfig, result *iperf3.TestResult) (metrics.ClientStats, []string) {
+	stats := metrics.ClientStats{
+		CPUPercent:       result.ClientCPUPercent,
+		ServerCPUPercent: result.ServerCPUPercent,
+	}
+	var reasons []string
+
+	if result.ClientCPUPercent >= cfg.Bottleneck.CPUPercent {
+		reasons = append(reasons, fmt.Sprintf("iperf3 used %.0f%% CPU", result.ClientCPUPercent))
+	}
+
+	iface := cfg.Bottleneck.Interface
+	if iface == "" {
+		iface = target.Interface
+	}
+	if iface == "" {
+		var err error
+		if iface, err = netif.DefaultInterface(); err != nil {
+			log.Printf("Warning: link speed not checked: %v", err)
+			return stats, reasons
+		}
+	}
+
+	fastest := math.Max(result.DownloadMbps, result.UploadMbps)
+	share := cfg.Bottleneck.LinkPercent / 100
+
+	wireless, ok, err := netif.ReadWireless(iface)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if ok {
+		stats.Wireless = true
+		stats.WifiSignalDBm = wireless.SignalDBm
+		stats.WifiBitrateMbps = wireless.BitrateMbps
+		if wireless.BitrateMbps > 0 && fastest >= wireless.BitrateMbps*wifiEfficiency*share {
+			reasons = append(reasons, fmt.Sprintf("Wi-Fi on %s runs at %.0f Mbps", iface, wireless.BitrateMbps))
+		}
+		return stats, reasons
+	}
+
+	speed, err := netif.LinkSpeed(iface)
+	if err != nil {
+		// Virtual and tunnel interfaces have no link speed
+		return stats, reasons
+	}
+	stats.LinkSpeedMbps = float64(speed)
+	if fastest >= stats.LinkSpeedMbps*share {
+		reasons = append(reasons, fmt.Sprintf("%s links at %d Mbps", iface, speed))
+	}
+
+	return stats, reasons
+}
+
+// clientMetrics exports the client statistics of a test and logs when the client was the bottleneck
+func clientMetrics(cfg *config.Config, target config.TargetConfig, result *iperf3.TestResult, labels metrics.MetricLabels) []*io_prometheus_client.MetricFamily {
+	stats, reasons := detectClientLimits(cfg, target, result)
+	stats.Limited = len(reasons) > 0
+	if stats.Limited {
+		log.Printf("Warning: result is client limited: %s", strings.Join(reasons, ", "))
+	}
+
+	return metrics.ExportClientMetrics(stats, labels)
+}
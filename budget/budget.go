@@ -0,0 +1,123 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config holds the data budget of a metered connection
+type Config struct {
+	MonthlyBytes int64
+	ResetDay     int    // day of the month the billing period starts, 1 to 28
+	StatePath    string // file keeping the usage between runs
+}
+
+// usage is the persisted data use of the current billing period
+type usage struct {
+	PeriodStart    time.Time `json:"period_start"`
+	UsedBytes      int64     `json:"used_bytes"`
+	BytesPerSecond float64   `json:"bytes_per_second"` // transfer rate of the last probe, both directions
+}
+
+// Tracker keeps track of the data used by tests in the current billing period
+type Tracker struct {
+	config Config
+	usage  usage
+}
+
+// NewTracker creates a tracker and loads the usage from disk, starting over in a new billing period
+func NewTracker(config Config, now time.Time) (*Tracker, error) {
+	t := &Tracker{config: config}
+
+	data, err := os.ReadFile(config.StatePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read data usage: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &t.usage); err != nil {
+			return nil, fmt.Errorf("failed to parse data usage: %w", err)
+		}
+	}
+
+	if start := t.periodStart(now); !t.usage.PeriodStart.Equal(start) {
+		t.usage = usage{PeriodStart: start, BytesPerSecond: t.usage.BytesPerSecond}
+	}
+
+	return t, nil
+}
+
+// periodStart returns the start of the billing period containing now
+func (t *Tracker) periodStart(now time.Time) time.Time {
+	day := t.config.ResetDay
+	if day < 1 {
+		day = 1
+	}
+	start := time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// Used returns the bytes used in the current billing period
+func (t *Tracker) Used() int64 {
+	return t.usage.UsedBytes
+}
+
+// Remaining returns the bytes left in the current billing period
+func (t *Tracker) Remaining() int64 {
+	if t.usage.UsedBytes >= t.config.MonthlyBytes {
+		return 0
+	}
+	return t.config.MonthlyBytes - t.usage.UsedBytes
+}
+
+// Allowance spreads the remaining bytes over the runs left in the billing period
+// runsPerInterval is the number of tests sharing the budget every interval
+func (t *Tracker) Allowance(now time.Time, interval time.Duration, runsPerInterval int) int64 {
+	end := t.usage.PeriodStart.AddDate(0, 1, 0)
+	runs := int64(end.Sub(now)/interval) + 1
+	if runsPerInterval > 1 {
+		runs *= int64(runsPerInterval)
+	}
+	return t.Remaining() / runs
+}
+
+// BytesPerSecond returns the transfer rate seen by the last probe, 0 when there was none
+func (t *Tracker) BytesPerSecond() float64 {
+	return t.usage.BytesPerSecond
+}
+
+// SetBytesPerSecond records the transfer rate seen by a probe
+func (t *Tracker) SetBytesPerSecond(rate float64) {
+	t.usage.BytesPerSecond = rate
+}
+
+// Add counts bytes transferred by a test
+func (t *Tracker) Add(bytes int64) {
+	t.usage.UsedBytes += bytes
+}
+
+// Save writes the usage atomically
+func (t *Tracker) Save() error {
+	data, err := json.MarshalIndent(t.usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode data usage: %w", err)
+	}
+
+	tmp := t.config.StatePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(t.config.StatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write data usage: %w", err)
+	}
+	if err := os.Rename(tmp, t.config.StatePath); err != nil {
+		return fmt.Errorf("failed to write data usage: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed servers.yaml
+var bundled []byte
+
+// Server is a public iperf3 server of the catalog
+type Server struct {
+	Host      string  `yaml:"host" json:"host"`
+	Ports     string  `yaml:"ports" json:"ports"` // single port or range, e.g. "5201" or "5200-5209"
+	City      string  `yaml:"city" json:"city"`
+	Country   string  `yaml:"country" json:"country"`
+	Latitude  float64 `yaml:"latitude" json:"latitude"`
+	Longitude float64 `yaml:"longitude" json:"longitude"`
+}
+
+// file is the layout of a catalog file
+type file struct {
+	Servers []Server `yaml:"servers"`
+}
+
+// PortRange returns the first and last port the server listens on
+func (s Server) PortRange() (first, last int, err error) {
+	from, to, isRange := strings.Cut(s.Ports, "-")
+	first, err = strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid ports %q of %s", s.Ports, s.Host)
+	}
+	last = first
+	if isRange {
+		last, err = strconv.Atoi(strings.TrimSpace(to))
+		if err != nil || last < first {
+			return 0, 0, fmt.Errorf("invalid ports %q of %s", s.Ports, s.Host)
+		}
+	}
+	return first, last, nil
+}
+
+// Port returns a random port of the server's range, spreading tests over its iperf3 instances
+func (s Server) Port() int {
+	first, last, err := s.PortRange()
+	if err != nil {
+		return 5201
+	}
+	return first + rand.Intn(last-first+1)
+}
+
+// Load returns the bundled catalog with the servers of a local catalog file merged over it
+// A server of the local file replaces a bundled server with the same host, an empty path uses the bundled catalog only
+func Load(path string) ([]Server, error) {
+	var catalog file
+	if err := yaml.Unmarshal(bundled, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse bundled server catalog: %w", err)
+	}
+	if path == "" {
+		return catalog.Servers, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server catalog: %w", err)
+	}
+	var local file
+	if err := yaml.Unmarshal(data, &local); err != nil {
+		return nil, fmt.Errorf("failed to parse server catalog %s: %w", path, err)
+	}
+
+	servers := make([]Server, 0, len(catalog.Servers)+len(local.Servers))
+	replaced := make(map[string]bool)
+	for _, s := range local.Servers {
+		if _, _, err := s.PortRange(); err != nil {
+			return nil, fmt.Errorf("server catalog %s: %w", path, err)
+		}
+		replaced[s.Host] = true
+		servers = append(servers, s)
+	}
+	for _, s := range catalog.Servers {
+		if !replaced[s.Host] {
+			servers = append(servers, s)
+		}
+	}
+
+	return servers, nil
+}
+
+// Nearest returns up to n servers closest to a location, nearest first
+func Nearest(servers []Server, latitude, longitude float64, n int) []Server {
+	sorted := append([]Server(nil), servers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return DistanceKm(latitude, longitude, sorted[i].Latitude, sorted[i].Longitude) <
+			DistanceKm(latitude, longitude, sorted[j].Latitude, sorted[j].Longitude)
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// DistanceKm returns the great circle distance between two coordinates
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
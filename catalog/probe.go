@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// probeAttempts is the number of connects per server, the fastest one counts
+const probeAttempts = 3
+
+// maxConcurrentProbes limits the connects in flight so they don't disturb each other
+const maxConcurrentProbes = 8
+
+// ProbeResult is the outcome of probing a server
+type ProbeResult struct {
+	Server Server
+	RTT    time.Duration // fastest TCP connect
+	Err    error
+}
+
+// Probe measures the TCP connect time to the first port of every server
+// Results are sorted by RTT with unreachable servers last
+func Probe(servers []Server, dialer *net.Dialer, network string) []ProbeResult {
+	results := make([]ProbeResult, len(servers))
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s Server) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = ProbeResult{Server: s}
+			first, _, err := s.PortRange()
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].RTT, results[i].Err = ConnectRTT(dialer, network, net.JoinHostPort(s.Host, strconv.Itoa(first)))
+		}(i, s)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		return results[i].RTT < results[j].RTT
+	})
+	return results
+}
+
+// ConnectRTT returns the fastest of a few TCP connects to an address
+func ConnectRTT(dialer *net.Dialer, network, address string) (time.Duration, error) {
+	var best time.Duration
+	var lastErr error
+
+	for i := 0; i < probeAttempts; i++ {
+		start := time.Now()
+		conn, err := dialer.Dial(network, address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtt := time.Since(start)
+		conn.Close()
+
+		if best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	if best == 0 {
+		return 0, lastErr
+	}
+
+	return best, nil
+}
@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Selection is the outcome of automatic server selection, kept between runs
+type Selection struct {
+	SelectedAt time.Time  `json:"selected_at"`
+	Servers    []Selected `json:"servers"`
+
+	// Download speed of the first run after selection, later runs are compared against it
+	BaselineMbps float64 `json:"baseline_mbps"`
+}
+
+// Selected is a server picked by automatic selection
+type Selected struct {
+	Server
+	RTTMs float64 `json:"rtt_ms"`
+}
+
+// Valid reports whether the selection can still be used
+func (s *Selection) Valid(ttl time.Duration) bool {
+	return s != nil && len(s.Servers) > 0 && time.Since(s.SelectedAt) < ttl
+}
+
+// Select picks the n fastest reachable servers from probe results
+func Select(results []ProbeResult, n int) *Selection {
+	selection := &Selection{SelectedAt: time.Now()}
+	for _, r := range results {
+		if r.Err != nil || len(selection.Servers) == n {
+			break
+		}
+		selection.Servers = append(selection.Servers, Selected{
+			Server: r.Server,
+			RTTMs:  float64(r.RTT.Microseconds()) / 1000,
+		})
+	}
+	return selection
+}
+
+// LoadSelection reads a selection, a missing file returns nil
+func LoadSelection(path string) (*Selection, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server selection: %w", err)
+	}
+
+	var selection Selection
+	if err := json.Unmarshal(data, &selection); err != nil {
+		return nil, fmt.Errorf("failed to parse server selection %s: %w", path, err)
+	}
+	return &selection, nil
+}
+
+// Save writes the selection atomically
+func (s *Selection) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode server selection: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write server selection: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write server selection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"ibenc/iperf3"
+)
+
+// Status is a Nagios plugin state
+type Status int
+
+// Nagios plugin states, their values are the process exit codes
+const (
+	OK Status = iota
+	Warning
+	Critical
+	Unknown
+)
+
+// String returns the state name as printed in the plugin output
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Warning:
+		return "WARNING"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Thresholds holds warning and critical levels for each measurement
+// A zero value means the threshold is not set
+type Thresholds struct {
+	WarnDownload float64 // Mbps, alert when download drops below
+	CritDownload float64
+	WarnUpload   float64 // Mbps, alert when upload drops below
+	CritUpload   float64
+	WarnLatency  float64 // ms, alert when latency rises above
+	CritLatency  float64
+	WarnJitter   float64 // ms, alert when jitter rises above
+	CritJitter   float64
+	WarnLoss     float64 // percent, alert when packet loss rises above
+	CritLoss     float64
+}
+
+// Result is the outcome of evaluating a test result against thresholds
+type Result struct {
+	Status   Status
+	Problems []string
+	Perfdata []string
+}
+
+// Evaluate compares the test result with the thresholds
+func Evaluate(result *iperf3.TestResult, t Thresholds) Result {
+	r := Result{Status: OK}
+
+	r.below("download", result.DownloadMbps, t.WarnDownload, t.CritDownload, "Mbps")
+	r.below("upload", result.UploadMbps, t.WarnUpload, t.CritUpload, "Mbps")
+	r.above("latency", result.LatencyMs, t.WarnLatency, t.CritLatency, "ms")
+	r.above("jitter", result.JitterMs, t.WarnJitter, t.CritJitter, "ms")
+	r.above("packet loss", result.PacketLossPercent, t.WarnLoss, t.CritLoss, "%")
+
+	// Perfdata uses Nagios units where one exists (ms, %), throughput is unitless Mbps
+	// Throughput levels use the "min:" range form since they alert on low values
+	r.Perfdata = []string{
+		perfdata("download_mbps", result.DownloadMbps, "", minLevel(t.WarnDownload), minLevel(t.CritDownload), ""),
+		perfdata("upload_mbps", result.UploadMbps, "", minLevel(t.WarnUpload), minLevel(t.CritUpload), ""),
+		perfdata("latency", result.LatencyMs, "ms", maxLevel(t.WarnLatency), maxLevel(t.CritLatency), ""),
+		perfdata("jitter", result.JitterMs, "ms", maxLevel(t.WarnJitter), maxLevel(t.CritJitter), ""),
+		perfdata("packet_loss", result.PacketLossPercent, "%", maxLevel(t.WarnLoss), maxLevel(t.CritLoss), "100"),
+	}
+
+	return r
+}
+
+// Line formats the single line of plugin output including perfdata
+func (r Result) Line(result *iperf3.TestResult) string {
+	summary := fmt.Sprintf("Download %.2f Mbps, Upload %.2f Mbps, Latency %.2f ms, Jitter %.2f ms, Loss %.2f%%",
+		result.DownloadMbps, result.UploadMbps, result.LatencyMs, result.JitterMs, result.PacketLossPercent)
+	if len(r.Problems) > 0 {
+		summary = strings.Join(r.Problems, ", ") + " - " + summary
+	}
+	return fmt.Sprintf("IBENC %s - %s | %s", r.Status, summary, strings.Join(r.Perfdata, " "))
+}
+
+// below raises the status when value is lower than a threshold
+func (r *Result) below(name string, value, warn, crit float64, unit string) {
+	switch {
+	case crit > 0 && value < crit:
+		r.raise(Critical, fmt.Sprintf("%s %.2f %s < %.2f", name, value, unit, crit))
+	case warn > 0 && value < warn:
+		r.raise(Warning, fmt.Sprintf("%s %.2f %s < %.2f", name, value, unit, warn))
+	}
+}
+
+// above raises the status when value is higher than a threshold
+func (r *Result) above(name string, value, warn, crit float64, unit string) {
+	switch {
+	case crit > 0 && value > crit:
+		r.raise(Critical, fmt.Sprintf("%s %.2f %s > %.2f", name, value, unit, crit))
+	case warn > 0 && value > warn:
+		r.raise(Warning, fmt.Sprintf("%s %.2f %s > %.2f", name, value, unit, warn))
+	}
+}
+
+// raise records a problem and keeps the worst status seen so far
+func (r *Result) raise(status Status, problem string) {
+	if status > r.Status {
+		r.Status = status
+	}
+	r.Problems = append(r.Problems, problem)
+}
+
+// perfdata formats a label=value[UOM];[warn];[crit];[min];[max] entry
+func perfdata(label string, value float64, unit string, warn, crit string, max string) string {
+	return fmt.Sprintf("%s=%.2f%s;%s;%s;0;%s", label, value, unit, warn, crit, max)
+}
+
+// maxLevel formats an upper threshold, leaving it empty when unset
+func maxLevel(v float64) string {
+	if v <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// minLevel formats a lower threshold as a "min:" range, leaving it empty when unset
+func minLevel(v float64) string {
+	if v <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f:", v)
+}
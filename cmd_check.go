@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"ibenc/check"
+	"ibenc/config"
+)
+
+// runCheck runs a test as a Nagios/Icinga plugin and returns the plugin exit code
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	configPath := fs.String("config", "ibenc.yaml", "path to configuration file")
+	server := fs.String("server", "", "iperf3 server, overrides the configuration file")
+	port := fs.Int("port", 5201, "iperf3 server port, used with -server")
+	duration := fs.Int("duration", 10, "test duration in seconds, used with -server")
+	targetName := fs.String("target", "", "name of the configured target to test, defaults to the first")
+	overrides := config.RegisterFlags(fs)
+
+	var t check.Thresholds
+	fs.Float64Var(&t.WarnDownload, "warn-download", 0, "warning when download is below this many Mbps")
+	fs.Float64Var(&t.CritDownload, "crit-download", 0, "critical when download is below this many Mbps")
+	fs.Float64Var(&t.WarnUpload, "warn-upload", 0, "warning when upload is below this many Mbps")
+	fs.Float64Var(&t.CritUpload, "crit-upload", 0, "critical when upload is below this many Mbps")
+	fs.Float64Var(&t.WarnLatency, "warn-latency", 0, "warning when latency is above this many ms")
+	fs.Float64Var(&t.CritLatency, "crit-latency", 0, "critical when latency is above this many ms")
+	fs.Float64Var(&t.WarnJitter, "warn-jitter", 0, "warning when jitter is above this many ms")
+	fs.Float64Var(&t.CritJitter, "crit-jitter", 0, "critical when jitter is above this many ms")
+	fs.Float64Var(&t.WarnLoss, "warn-loss", 0, "warning when packet loss is above this percentage")
+	fs.Float64Var(&t.CritLoss, "crit-loss", 0, "critical when packet loss is above this percentage")
+
+	if err := fs.Parse(args); err != nil {
+		return int(check.Unknown)
+	}
+
+	// Plugin output must be a single line on stdout, keep runner logs out of it
+	log.SetOutput(io.Discard)
+
+	// Without an explicit server the target comes from the configuration file
+	cfg := &config.Config{}
+	target := config.TargetConfig{Server: *server, Port: *port, Duration: *duration}
+	if *server == "" {
+		var err error
+		cfg, err = config.LoadConfigWithOverrides(*configPath, overrides)
+		if err != nil {
+			fmt.Printf("IBENC %s - failed to load configuration: %v\n", check.Unknown, err)
+			return int(check.Unknown)
+		}
+
+		targets := cfg.EffectiveTargets()
+		target = targets[0]
+		if *targetName != "" {
+			found := false
+			for _, t := range targets {
+				if t.Name == *targetName {
+					target, found = t, true
+				}
+			}
+			if !found {
+				fmt.Printf("IBENC %s - target %s is not configured\n", check.Unknown, *targetName)
+				return int(check.Unknown)
+			}
+		}
+	}
+
+	// A plugin reports one result, a dual-stack target would need a check per family
+	if target.AddressFamily == "both" {
+		fmt.Printf("IBENC %s - address_family both is not supported by check, pin the target to ipv4 or ipv6\n", check.Unknown)
+		return int(check.Unknown)
+	}
+
+	// Tests share the run lock with the timer and the daemon, a -server test takes the lock of the
+	// iperf3 section's uplink with the lock settings of the configuration file when it loads
+	lockCfg, lockTarget := cfg, target
+	if *server != "" {
+		if loaded, err := config.LoadConfigWithOverrides(*configPath, overrides); err == nil {
+			lockCfg, lockTarget.WAN = loaded, loaded.Iperf3.WAN
+		}
+	}
+	lock, err := acquireRunLock(lockCfg, lockTarget)
+	if err != nil {
+		fmt.Printf("IBENC %s - %v\n", check.Unknown, err)
+		return int(check.Unknown)
+	}
+	defer lock.release()
+
+	testResult, err := runServers(cfg, target, nil)
+	if err != nil {
+		fmt.Printf("IBENC %s - test against %s failed: %v\n", check.Unknown, strings.Join(target.ServerList(), ", "), err)
+		return int(check.Unknown)
+	}
+	if lock.lost() != nil {
+		fmt.Printf("IBENC %s - %v\n", check.Unknown, lock.lost())
+		return int(check.Unknown)
+	}
+
+	result := check.Evaluate(testResult, t)
+	fmt.Println(result.Line(testResult))
+
+	return int(result.Status)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"ibenc/config"
+	"ibenc/remote"
+)
+
+// runConfig handles the config subcommands and returns the process exit code
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: ibenc config check [-config file] [-connect]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configPath := fs.String("config", "ibenc.yaml", "path to configuration file")
+	connect := fs.Bool("connect", false, "also send an empty remote write request and write to the textfile directory to test the sinks")
+	overrides := config.RegisterFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfigWithOverrides(*configPath, overrides)
+	if err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			fmt.Printf("%s: %d problem(s)\n", *configPath, len(validationErr.Problems))
+			for _, problem := range validationErr.Problems {
+				fmt.Printf("  ✗ %s\n", problem)
+			}
+		} else {
+			fmt.Printf("%s: %v\n", *configPath, err)
+		}
+		return 1
+	}
+
+	for _, warning := range cfg.Warnings() {
+		fmt.Printf("  ! %s\n", warning)
+	}
+	fmt.Printf("✓ %s is valid\n", *configPath)
+
+	if *connect && cfg.Textfile.Directory != "" {
+		f, err := os.CreateTemp(cfg.Textfile.Directory, ".ibenc-check-*")
+		if err != nil {
+			fmt.Printf("✗ textfile directory %s is not writable: %v\n", cfg.Textfile.Directory, err)
+			return 1
+		}
+		f.Close()
+		os.Remove(f.Name())
+		fmt.Printf("✓ textfile directory %s is writable\n", cfg.Textfile.Directory)
+	}
+
+	if *connect && cfg.Prometheus.URL != "" {
+		writer := remote.NewWriter(remote.Config{
+			PrometheusURL: cfg.Prometheus.URL,
+			Username:      cfg.Prometheus.Username,
+			Password:      cfg.Prometheus.Password,
+		})
+		if err := writer.Ping(); err != nil {
+			fmt.Printf("✗ remote write to %s failed: %v\n", cfg.Prometheus.URL, err)
+			return 1
+		}
+		fmt.Printf("✓ remote write to %s accepted\n", cfg.Prometheus.URL)
+	}
+
+	return 0
+}
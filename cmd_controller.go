@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"ibenc/config"
+	"ibenc/fleet"
+)
+
+// runController serves configuration and schedules to fleet agents and returns the process exit code
+func runController(args []string) int {
+	fs := flag.NewFlagSet("controller", flag.ContinueOnError)
+	configPath := fs.String("config", "controller.yaml", "path to the agent configuration file")
+	listen := fs.String("listen", ":5300", "address to listen on")
+	token := fs.String("token", os.Getenv("IBENC_CONTROLLER_TOKEN"), "shared secret agents must send (default $IBENC_CONTROLLER_TOKEN)")
+	resultsFile := fs.String("results", "", "JSON lines file to append agent results to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	controller := fleet.NewController(*token, *resultsFile)
+	if err := controller.Load(*configPath); err != nil {
+		log.Printf("Failed to load controller configuration: %v\n", err)
+		return 1
+	}
+	if *token == "" {
+		log.Println("Warning: controller runs without a token, anyone on the network can register agents")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Agents pick up changes of the file with their next heartbeat
+	reload := func() {
+		if err := controller.Load(*configPath); err != nil {
+			log.Printf("Controller configuration reload failed, keeping previous configuration: %v\n", err)
+			return
+		}
+		log.Printf("Controller configuration reloaded from %s\n", *configPath)
+	}
+	if err := config.Watch(ctx, *configPath, reload); err != nil {
+		log.Printf("Warning: not watching %s for changes: %v", *configPath, err)
+	}
+
+	server := &http.Server{Addr: *listen, Handler: controller.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("ibenc %s controller listening on %s\n", version, *listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Controller failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"ibenc/config"
+	"ibenc/grafana"
+)
+
+const grafanaUsage = "usage: ibenc grafana dashboard|rules [-config file] [-output file] [-upload] [-plan-percent n] [-stale duration] [-namespace name]"
+
+// minStale keeps hourly timer runs from looking stale between runs
+const minStale = 3 * time.Hour
+
+// runGrafana generates a dashboard or rule file for the configuration, writes it or uploads it,
+// and returns the process exit code
+func runGrafana(args []string) int {
+	if len(args) == 0 || (args[0] != "dashboard" && args[0] != "rules") {
+		fmt.Fprintln(os.Stderr, grafanaUsage)
+		return 2
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet("grafana "+kind, flag.ContinueOnError)
+	configPath := fs.String("config", "ibenc.yaml", "path to configuration file")
+	output := fs.String("output", "", "file to write to instead of standard output")
+	upload := fs.Bool("upload", false, "upload to grafana.url instead of writing")
+	planPercent := fs.Float64("plan-percent", 80, "share of the plan speed below which speeds are flagged")
+	stale := fs.Duration("stale", 0, "alert when no results arrive for this long (default: three test intervals, at least 3h)")
+	namespace := fs.String("namespace", "ibenc", "rule namespace to upload rules to")
+	overrides := config.RegisterFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfigWithOverrides(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	opts := grafanaOptions(cfg, *planPercent, *stale)
+
+	var data []byte
+	if kind == "dashboard" {
+		dashboard := grafana.NewDashboard(opts)
+		if *upload {
+			return uploadGrafana(cfg, func(client *grafana.Client) error {
+				return client.UploadDashboard(dashboard, cfg.Grafana.Folder)
+			}, "dashboard "+dashboard.Title)
+		}
+		data, err = json.MarshalIndent(dashboard, "", "  ")
+		data = append(data, '\n')
+	} else {
+		rules := grafana.NewRules(opts)
+		if *upload {
+			if cfg.Grafana.Datasource == "" {
+				fmt.Fprintln(os.Stderr, "grafana.datasource is required to upload rules")
+				return 1
+			}
+			return uploadGrafana(cfg, func(client *grafana.Client) error {
+				return client.UploadRules(rules, cfg.Grafana.Datasource, *namespace)
+			}, "rules to namespace "+*namespace)
+		}
+		data, err = yaml.Marshal(rules)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode %s: %v\n", kind, err)
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// grafanaOptions describes the deployment of a configuration to the dashboard and rule generators
+func grafanaOptions(cfg *config.Config, planPercent float64, stale time.Duration) grafana.Options {
+	selector := make(map[string]string)
+	for name, value := range map[string]string{
+		"location":     cfg.Metrics.Location,
+		"isp_name":     cfg.Metrics.ISPName,
+		"package_name": cfg.Metrics.PackageName,
+	} {
+		if value != "" {
+			selector[name] = value
+		}
+	}
+
+	// Custom labels select the series only when every target keeps them
+	targets := cfg.EffectiveTargets()
+	for name, value := range cfg.Metrics.Labels {
+		shared := value != ""
+		for _, target := range targets {
+			if cfg.CustomLabels(target)[name] != value {
+				shared = false
+			}
+		}
+		if shared {
+			selector[name] = value
+		}
+	}
+
+	if stale == 0 {
+		for _, target := range targets {
+			stale = max(stale, 3*interval(cfg, target))
+		}
+		stale = max(stale, minStale)
+	}
+
+	return grafana.Options{
+		Title:            "ibenc - " + cfg.Metrics.Location,
+		Selector:         selector,
+		Targets:          len(cfg.Targets) > 0,
+		PlanDownloadMbps: cfg.Metrics.PlanDownloadMbps,
+		PlanUploadMbps:   cfg.Metrics.PlanUploadMbps,
+		PlanPercent:      planPercent,
+		Datasource:       cfg.Grafana.Datasource,
+		StaleAfter:       stale,
+		CrossTraffic:     cfg.CrossTraffic.Enabled,
+		EstimateCapacity: cfg.CrossTraffic.EstimateCapacity,
+		Bottleneck:       cfg.Bottleneck.Enabled,
+		Budget:           cfg.Budget.MonthlyGB > 0,
+		// Dual-writing keeps the v1 names, so dashboards only switch once they are gone
+		SchemaV2: cfg.Metrics.Schema == "v2",
+	}
+}
+
+// uploadGrafana runs an upload against grafana.url and reports the outcome
+func uploadGrafana(cfg *config.Config, upload func(*grafana.Client) error, what string) int {
+	if cfg.Grafana.URL == "" || cfg.Grafana.Token == "" {
+		fmt.Fprintln(os.Stderr, "grafana.url and grafana.token are required to upload")
+		return 1
+	}
+
+	if err := upload(grafana.NewClient(cfg.Grafana.URL, cfg.Grafana.Token)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to upload %s: %v\n", what, err)
+		return 1
+	}
+	fmt.Printf("✓ Uploaded %s to %s\n", what, cfg.Grafana.URL)
+	return 0
+}
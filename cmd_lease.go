@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"ibenc/lease"
+)
+
+// runLeaseServer serves uplink leases to the ibenc instances of a LAN and returns the process exit code
+func runLeaseServer(args []string) int {
+	fs := flag.NewFlagSet("lease-server", flag.ContinueOnError)
+	listen := fs.String("listen", ":5299", "address to listen on")
+	token := fs.String("token", os.Getenv("IBENC_LEASE_TOKEN"), "shared secret clients must send (default $IBENC_LEASE_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *token == "" {
+		log.Println("Warning: lease server runs without a token, anyone on the network can take leases")
+	}
+
+	server := lease.NewServer(*token)
+	log.Printf("Lease server listening on %s\n", *listen)
+	if err := http.ListenAndServe(*listen, server.Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "Lease server failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
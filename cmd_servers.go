@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"ibenc/catalog"
+	"ibenc/config"
+	"ibenc/iperf3"
+	"ibenc/lease"
+)
+
+const serversUsage = "usage: ibenc servers list|probe|test [-config file] [-source catalog|config] [-target name] [-sort key] [-format table|json] [-duration seconds]"
+
+// serverRow is one server in the output of ibenc servers
+type serverRow struct {
+	Host         string  `json:"host"`
+	Port         int     `json:"port"`
+	Ports        string  `json:"ports,omitempty"`
+	Location     string  `json:"location,omitempty"`
+	DistanceKm   float64 `json:"distance_km,omitempty"`
+	RTTMs        float64 `json:"rtt_ms,omitempty"`
+	Status       string  `json:"status,omitempty"` // idle, busy, error or unreachable
+	Error        string  `json:"error,omitempty"`
+	DownloadMbps float64 `json:"download_mbps,omitempty"`
+	UploadMbps   float64 `json:"upload_mbps,omitempty"`
+
+	server catalog.Server      // catalog entry, empty for configured servers
+	target config.TargetConfig // source address, interface and address family to test with
+}
+
+// runServersCommand lists, probes or test-runs catalog or configured servers and returns the process exit code
+func runServersCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "probe" && args[0] != "test") {
+		fmt.Fprintln(os.Stderr, serversUsage)
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("servers "+action, flag.ContinueOnError)
+	configPath := fs.String("config", "ibenc.yaml", "path to configuration file")
+	source := fs.String("source", "catalog", "servers to show: catalog or config")
+	targetName := fs.String("target", "", "target whose address family, source address and interface are used")
+	sortBy := fs.String("sort", "", "sort by host, distance, rtt, download or upload")
+	format := fs.String("format", "table", "output format: table or json")
+	duration := fs.Int("duration", 3, "test duration in seconds for servers test")
+	overrides := config.RegisterFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *source != "catalog" && *source != "config" {
+		fmt.Fprintln(os.Stderr, serversUsage)
+		return 2
+	}
+
+	// The catalog is usable without a valid configuration
+	cfg, err := config.LoadConfigWithOverrides(*configPath, overrides)
+	if err != nil {
+		if *source == "config" {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		cfg = &config.Config{Iperf3: config.Iperf3Config{Port: 5201}}
+	}
+
+	target := cfg.EffectiveTargets()[0]
+	if *targetName != "" {
+		found := false
+		for _, t := range cfg.EffectiveTargets() {
+			if t.Name == *targetName {
+				target, found = t, true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Target %s is not configured\n", *targetName)
+			return 1
+		}
+	}
+
+	var rows []*serverRow
+	if *source == "catalog" {
+		rows, err = catalogRows(cfg, target)
+	} else {
+		rows = configuredRows(cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if action == "probe" || action == "test" {
+		probeRows(rows)
+	}
+	if action == "test" {
+		testRows(cfg, rows, *duration)
+	}
+
+	// Each action sorts by what it measured unless asked otherwise
+	by := *sortBy
+	if by == "" {
+		by = map[string]string{"list": "distance", "probe": "rtt", "test": "download"}[action]
+	}
+	if err := sortRows(rows, by); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode output: %v\n", err)
+			return 1
+		}
+	case "table":
+		printRows(rows, action)
+	default:
+		fmt.Fprintln(os.Stderr, serversUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// catalogRows returns the catalog servers with their distance from the configured location
+func catalogRows(cfg *config.Config, target config.TargetConfig) ([]*serverRow, error) {
+	servers, err := catalog.Load(cfg.ServerSelection.Catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := cfg.ServerSelection
+	rows := make([]*serverRow, 0, len(servers))
+	for _, s := range servers {
+		first, _, err := s.PortRange()
+		if err != nil {
+			return nil, err
+		}
+		row := &serverRow{
+			Host:     s.Host,
+			Port:     first,
+			Ports:    s.Ports,
+			Location: s.City + ", " + s.Country,
+			server:   s,
+			target:   target,
+		}
+		if sel.Latitude != 0 || sel.Longitude != 0 {
+			row.DistanceKm = catalog.DistanceKm(sel.Latitude, sel.Longitude, s.Latitude, s.Longitude)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// configuredRows returns the servers of every target, leaving out auto
+func configuredRows(cfg *config.Config) []*serverRow {
+	var rows []*serverRow
+	seen := make(map[string]bool)
+	for _, t := range cfg.EffectiveTargets() {
+		for _, server := range t.ServerList() {
+			key := fmt.Sprintf("%s:%d", server, t.Port)
+			if server == autoServer || seen[key] {
+				continue
+			}
+			seen[key] = true
+			rows = append(rows, &serverRow{Host: server, Port: t.Port, target: t})
+		}
+	}
+	return rows
+}
+
+// probeRows checks reachability and busy status of every server with the iperf3 control handshake
+// Servers with a port range are idle when any of their ports is
+func probeRows(rows []*serverRow) {
+	sem := make(chan struct{}, 8)
+	var wg sync.WaitGroup
+
+	for _, row := range rows {
+		wg.Add(1)
+		go func(row *serverRow) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			first, last := row.Port, row.Port
+			if row.Ports != "" {
+				first, last, _ = row.server.PortRange()
+			}
+
+			row.Status = "unreachable"
+			for port := first; port <= last; port++ {
+				opts := targetOptions(&config.Config{}, row.target, endpoint{host: row.Host, port: port})
+				status, err := iperf3.Handshake(opts, probeTimeout)
+				if err != nil {
+					row.Error = err.Error()
+					if status.ConnectRTT == 0 {
+						// Other ports of an unreachable host won't do better
+						return
+					}
+					// Something listens but doesn't speak iperf3
+					if row.Status == "unreachable" {
+						row.Port = port
+						row.RTTMs = float64(status.ConnectRTT.Microseconds()) / 1000
+						row.Status = "error"
+					}
+					continue
+				}
+
+				row.Port = port
+				row.RTTMs = float64(status.ConnectRTT.Microseconds()) / 1000
+				row.Error = ""
+				if !status.Busy {
+					row.Status = "idle"
+					return
+				}
+				row.Status = "busy"
+			}
+		}(row)
+	}
+	wg.Wait()
+}
+
+// testRows runs a short test against every idle server, one at a time so the tests don't compete
+// Each test takes the run lock of the target's uplink, servers are marked busy while another test holds it
+func testRows(cfg *config.Config, rows []*serverRow, duration int) {
+	for _, row := range rows {
+		if row.Status != "idle" {
+			continue
+		}
+
+		lock, err := acquireRunLock(cfg, row.target)
+		if err != nil {
+			row.Error = err.Error()
+			if errors.Is(err, lease.ErrBusy) {
+				row.Status = "busy"
+			}
+			continue
+		}
+
+		opts := targetOptions(&config.Config{}, row.target, endpoint{host: row.Host, port: row.Port})
+		opts.Duration = duration
+		result, err := iperf3.RunBothTestsWithOptions(opts)
+		if err == nil {
+			err = lock.lost()
+		}
+		lock.release()
+		if err != nil {
+			row.Error = err.Error()
+			continue
+		}
+		row.DownloadMbps = result.DownloadMbps
+		row.UploadMbps = result.UploadMbps
+	}
+}
+
+// sortRows orders the rows by a column, servers without a value for it go last
+func sortRows(rows []*serverRow, by string) error {
+	var less func(a, b *serverRow) bool
+	switch by {
+	case "host":
+		less = func(a, b *serverRow) bool { return a.Host < b.Host }
+	case "distance":
+		less = func(a, b *serverRow) bool { return a.DistanceKm < b.DistanceKm }
+	case "rtt":
+		less = func(a, b *serverRow) bool { return a.RTTMs > 0 && (b.RTTMs == 0 || a.RTTMs < b.RTTMs) }
+	case "download":
+		less = func(a, b *serverRow) bool { return a.DownloadMbps > b.DownloadMbps }
+	case "upload":
+		less = func(a, b *serverRow) bool { return a.UploadMbps > b.UploadMbps }
+	default:
+		return fmt.Errorf("unknown sort key %q, use host, distance, rtt, download or upload", by)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	return nil
+}
+
+// printRows writes the rows as a table with the columns relevant to the action
+func printRows(rows []*serverRow, action string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	header := []string{"HOST", "PORT", "LOCATION", "DISTANCE"}
+	if action != "list" {
+		header = append(header, "RTT", "STATUS")
+	}
+	if action == "test" {
+		header = append(header, "DOWNLOAD", "UPLOAD")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		port := fmt.Sprint(row.Port)
+		if action == "list" && row.Ports != "" {
+			port = row.Ports
+		}
+		cols := []string{row.Host, port, row.Location, formatValue(row.DistanceKm, "%.0f km")}
+		if action != "list" {
+			cols = append(cols, formatValue(row.RTTMs, "%.1f ms"), row.Status)
+		}
+		if action == "test" {
+			cols = append(cols, formatValue(row.DownloadMbps, "%.1f Mbps"), formatValue(row.UploadMbps, "%.1f Mbps"))
+		}
+		fmt.Fprintln(w, strings.Join(cols, "\t"))
+	}
+}
+
+// formatValue formats a measurement, leaving it blank when it is missing
+func formatValue(value float64, format string) string {
+	if value == 0 {
+		return "-"
+	}
+	return fmt.Sprintf(format, value)
+}
@@ -1,54 +1,278 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the entire application configuration
 type Config struct {
-	Prometheus PrometheusConfig `yaml:"prometheus"`
-	Iperf3     Iperf3Config     `yaml:"iperf3"`
-	Metrics    MetricsConfig    `yaml:"metrics"`
+	Prometheus   PrometheusConfig   `yaml:"prometheus"`
+	Textfile     TextfileConfig     `yaml:"textfile"`
+	Iperf3       Iperf3Config       `yaml:"iperf3"`
+	Targets      []TargetConfig     `yaml:"targets"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Alerts       AlertsConfig       `yaml:"alerts"`
+	CrossTraffic CrossTrafficConfig `yaml:"cross_traffic"`
+	Bottleneck   BottleneckConfig   `yaml:"bottleneck"`
+
+	ServerSelection ServerSelectionConfig `yaml:"server_selection"`
+	Budget          BudgetConfig          `yaml:"budget"`
+	Lock            LockConfig            `yaml:"lock"`
+	Controller      ControllerConfig      `yaml:"controller"`
+	API             APIConfig             `yaml:"api"`
+	Grafana         GrafanaConfig         `yaml:"grafana"`
+	Daemon          DaemonConfig          `yaml:"daemon"`
+	StateDir        string                `yaml:"state_dir"`
+
+	// Parsed YAML document, used to report line numbers
+	source *yaml.Node
+	// Keys in the document that don't match any setting
+	unknownKeys []Problem
+	// Problems that don't prevent loading, e.g. deprecated keys
+	warnings []string
+}
+
+// DaemonConfig holds settings for the long-running daemon mode
+type DaemonConfig struct {
+	Interval time.Duration `yaml:"interval"`
 }
 
 // PrometheusConfig holds Grafana Cloud authentication and endpoint details
 type PrometheusConfig struct {
-	URL      string `yaml:"url"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	URL          string `yaml:"url"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// TextfileConfig holds the node_exporter textfile collector directory metrics are written to
+type TextfileConfig struct {
+	Directory string `yaml:"directory"`
 }
 
 // Iperf3Config holds iperf3 test configuration
+// It is the only target when no targets are configured, and the defaults for targets otherwise
 type Iperf3Config struct {
-	Server   string `yaml:"server"`
-	Port     int    `yaml:"port"`
-	Duration int    `yaml:"duration"`
+	Server   string            `yaml:"server"`
+	Port     int               `yaml:"port"`
+	Duration int               `yaml:"duration"`
+	Parallel int               `yaml:"parallel"`
+	Protocol string            `yaml:"protocol"`
+	Bitrate  string            `yaml:"bitrate"`
+	Labels   map[string]string `yaml:"labels"`
+
+	// Address family, ipv4, ipv6 or both to compare them, empty lets iperf3 pick
+	AddressFamily string `yaml:"address_family"`
+
+	// Source selection for multi-WAN hosts, WAN names the uplink in the wan label
+	BindAddress string `yaml:"bind_address"`
+	Interface   string `yaml:"interface"`
+	WAN         string `yaml:"wan"`
+}
+
+// TargetConfig holds a measurement target with its own servers, options, labels and schedule
+// Unset options fall back to the iperf3 section
+type TargetConfig struct {
+	Name     string            `yaml:"name"`
+	Server   string            `yaml:"server"`
+	Servers  []string          `yaml:"servers"`
+	Port     int               `yaml:"port"`
+	Duration int               `yaml:"duration"`
+	Parallel int               `yaml:"parallel"`
+	Protocol string            `yaml:"protocol"`
+	Bitrate  string            `yaml:"bitrate"`
+	Labels   map[string]string `yaml:"labels"`
+	Interval time.Duration     `yaml:"interval"`
+
+	// Address family, ipv4, ipv6 or both to compare them, empty lets iperf3 pick
+	AddressFamily string `yaml:"address_family"`
+
+	// Source selection for multi-WAN hosts, WAN names the uplink in the wan label
+	BindAddress string `yaml:"bind_address"`
+	Interface   string `yaml:"interface"`
+	WAN         string `yaml:"wan"`
+
+	// Set on the targets split off by AddressFamilies, only their series get an ip_version label
+	DualStack bool `yaml:"-"`
+}
+
+// AddressFamilies splits a target with address_family both into one target per family
+func (t TargetConfig) AddressFamilies() []TargetConfig {
+	if t.AddressFamily != "both" {
+		return []TargetConfig{t}
+	}
+
+	ipv4, ipv6 := t, t
+	ipv4.DualStack, ipv6.DualStack = true, true
+	ipv4.AddressFamily = "ipv4"
+	ipv6.AddressFamily = "ipv6"
+	return []TargetConfig{ipv4, ipv6}
+}
+
+// IPVersion returns the ip_version label value, "4" or "6", empty when the family is not pinned
+func (t TargetConfig) IPVersion() string {
+	switch t.AddressFamily {
+	case "ipv4":
+		return "4"
+	case "ipv6":
+		return "6"
+	default:
+		return ""
+	}
+}
+
+// ServerList returns the servers of the target in the order they are tried
+func (t TargetConfig) ServerList() []string {
+	var servers []string
+	if t.Server != "" {
+		servers = append(servers, t.Server)
+	}
+	return append(servers, t.Servers...)
 }
 
 // MetricsConfig holds metric labels configuration
 type MetricsConfig struct {
-	Location    string `yaml:"location"`
-	ISPName     string `yaml:"isp_name"`
-	PackageName string `yaml:"package_name"`
+	Location    string            `yaml:"location"`
+	ISPName     string            `yaml:"isp_name"`
+	PackageName string            `yaml:"package_name"`
+	Labels      map[string]string `yaml:"labels"`
+
+	// Contracted speeds of the package, used by generated dashboards and alert rules
+	PlanDownloadMbps float64 `yaml:"plan_download_mbps"`
+	PlanUploadMbps   float64 `yaml:"plan_upload_mbps"`
+
+	// Histograms of interval samples: classic, native or both, none when empty
+	Histograms string `yaml:"histograms"`
+
+	// Metric names and units: v1, v2 or both to dual-write while dashboards migrate
+	Schema string `yaml:"schema"`
+}
+
+// CrossTrafficConfig holds settings for measuring non-test traffic on the WAN interface
+type CrossTrafficConfig struct {
+	Enabled          bool    `yaml:"enabled"`
+	Interface        string  `yaml:"interface"`
+	ThresholdMbps    float64 `yaml:"threshold_mbps"`
+	EstimateCapacity bool    `yaml:"estimate_capacity"`
+}
+
+// BottleneckConfig holds settings for detecting results limited by the measuring host
+type BottleneckConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Interface   string  `yaml:"interface"`
+	CPUPercent  float64 `yaml:"cpu_percent"`
+	LinkPercent float64 `yaml:"link_percent"`
+}
+
+// ServerSelectionConfig holds settings for picking servers from the catalog with server auto
+type ServerSelectionConfig struct {
+	Catalog        string        `yaml:"catalog"`
+	Latitude       float64       `yaml:"latitude"`
+	Longitude      float64       `yaml:"longitude"`
+	Candidates     int           `yaml:"candidates"`
+	Count          int           `yaml:"count"`
+	TTL            time.Duration `yaml:"ttl"`
+	DegradePercent float64       `yaml:"degrade_percent"`
+}
+
+// BudgetConfig holds the monthly data budget of a metered connection
+type BudgetConfig struct {
+	MonthlyGB     float64 `yaml:"monthly_gb"`
+	ResetDay      int     `yaml:"reset_day"`
+	MinDuration   int     `yaml:"min_duration"`
+	ProbeDuration int     `yaml:"probe_duration"`
+}
+
+// LockConfig holds settings for keeping throughput tests from overlapping
+type LockConfig struct {
+	Wait   time.Duration `yaml:"wait"`
+	Server string        `yaml:"server"`
+	Token  string        `yaml:"token"`
+}
+
+// ControllerConfig holds the fleet controller a daemon reports to
+type ControllerConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	ID    string `yaml:"id"`
+}
+
+// APIConfig holds the HTTP API of the daemon for on-demand tests
+type APIConfig struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+	UI     bool   `yaml:"ui"`
+}
+
+// GrafanaConfig holds the Grafana instance generated dashboards are uploaded to
+type GrafanaConfig struct {
+	URL        string `yaml:"url"`
+	Token      string `yaml:"token"`
+	Folder     string `yaml:"folder"`
+	Datasource string `yaml:"datasource"`
+}
+
+// AlertsConfig holds local alert rules and the webhooks they notify
+type AlertsConfig struct {
+	Rules          []AlertRuleConfig `yaml:"rules"`
+	Webhooks       []WebhookConfig   `yaml:"webhooks"`
+	MinInterval    time.Duration     `yaml:"min_interval"`
+	RepeatInterval time.Duration     `yaml:"repeat_interval"`
+}
+
+// AlertRuleConfig holds a single threshold rule on a test result field
+type AlertRuleConfig struct {
+	Name      string  `yaml:"name"`
+	Field     string  `yaml:"field"`
+	Op        string  `yaml:"op"`
+	Threshold float64 `yaml:"threshold"`
+	For       int     `yaml:"for"`
+}
+
+// WebhookConfig holds a notification endpoint and its payload format
+type WebhookConfig struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Format   string `yaml:"format"`
+	Template string `yaml:"template"`
 }
 
 // LoadConfig loads configuration from YAML file
 func LoadConfig(configPath string) (*Config, error) {
-	// Expand home directory if needed
-	if configPath == "" {
-		configPath = "ibenc.yaml"
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
 	}
 
-	if configPath[0:2] == "~/" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get home directory: %w", err)
-		}
-		configPath = filepath.Join(home, configPath[2:])
+	// Load secrets from files and systemd credentials
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfig parses the YAML file without validating it
+func readConfig(configPath string) (*Config, error) {
+	// Expand home directory if needed
+	configPath, err := expandPath(configPath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Read file
@@ -58,99 +282,271 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 
 	// Parse YAML
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
 	}
 
-	// Validate required fields
-	if err := cfg.Validate(); err != nil {
+	// Replace ${ENV_VAR} references
+	if err := interpolateEnv(&node); err != nil {
 		return nil, err
 	}
 
+	// Unknown keys such as typos are reported by Validate along with other problems
+	unknown, warnings := checkKeys(&node)
+
+	var cfg Config
+	if err := node.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+	cfg.source = &node
+	cfg.unknownKeys = unknown
+	cfg.warnings = warnings
+
 	return &cfg, nil
 }
 
+// expandPath applies the default config path and expands a leading ~/
+func expandPath(configPath string) (string, error) {
+	if configPath == "" {
+		configPath = "ibenc.yaml"
+	}
+
+	if strings.HasPrefix(configPath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		configPath = filepath.Join(home, configPath[2:])
+	}
+
+	return configPath, nil
+}
+
 // LoadConfigWithDefaults loads config and applies environment variable overrides
 func LoadConfigWithDefaults(configPath string) (*Config, error) {
-	cfg, err := LoadConfig(configPath)
+	return LoadConfigWithOverrides(configPath, nil)
+}
+
+// LoadConfigWithOverrides loads config, applies defaults, environment variables and
+// command line flags in that order, then validates the result
+// A missing config file is allowed so the configuration can come from the environment alone
+func LoadConfigWithOverrides(configPath string, flags Flags) (*Config, error) {
+	return LoadConfigWithOverlays(configPath, flags, nil)
+}
+
+// LoadConfigWithOverlays loads config like LoadConfigWithOverrides with YAML overlays,
+// such as the ones pushed by a fleet controller, applied over the file in order
+// Keys of an overlay replace the same keys of the file, maps are merged
+func LoadConfigWithOverlays(configPath string, flags Flags, overlays [][]byte) (*Config, error) {
+	cfg, err := readConfig(configPath)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if missing {
+		cfg = &Config{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, overlay := range overlays {
+		dec := yaml.NewDecoder(bytes.NewReader(overlay))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to apply config overlay %d: %w", i+1, err)
+		}
+	}
+
+	cfg.applyDefaults()
+
+	// Override with environment variables and flags if present
+	warnings, err := applyEnvOverrides(cfg)
 	if err != nil {
 		return nil, err
 	}
+	cfg.warnings = append(cfg.warnings, warnings...)
+	if err := flags.apply(cfg); err != nil {
+		return nil, err
+	}
 
-	// Override with environment variables if present
-	applyEnvOverrides(cfg)
+	// Load secrets from files and systemd credentials
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if err := cfg.Validate(); err != nil {
+		if missing {
+			return nil, fmt.Errorf("config file %s not found and environment is incomplete: %w", configPath, err)
+		}
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
-// Validate checks that all required fields are set
-func (c *Config) Validate() error {
-	// Prometheus validation
-	if c.Prometheus.URL == "" {
-		return fmt.Errorf("prometheus.url is required")
+// applyDefaults fills in optional settings that were left empty
+func (c *Config) applyDefaults() {
+	if c.Iperf3.Port == 0 {
+		c.Iperf3.Port = 5201
 	}
-	if c.Prometheus.Username == "" {
-		return fmt.Errorf("prometheus.username is required")
+	if c.Iperf3.Duration == 0 {
+		c.Iperf3.Duration = 10
 	}
-	if c.Prometheus.Password == "" {
-		return fmt.Errorf("prometheus.password is required")
+	if c.ServerSelection.Candidates == 0 {
+		c.ServerSelection.Candidates = 10
 	}
-
-	// Iperf3 validation
-	if c.Iperf3.Server == "" {
-		return fmt.Errorf("iperf3.server is required")
+	if c.ServerSelection.Count == 0 {
+		c.ServerSelection.Count = 3
 	}
-	if c.Iperf3.Port <= 0 || c.Iperf3.Port > 65535 {
-		return fmt.Errorf("iperf3.port must be between 1 and 65535")
+	if c.ServerSelection.TTL == 0 {
+		c.ServerSelection.TTL = 24 * time.Hour
 	}
-	if c.Iperf3.Duration <= 0 {
-		return fmt.Errorf("iperf3.duration must be greater than 0")
+	if c.ServerSelection.DegradePercent == 0 {
+		c.ServerSelection.DegradePercent = 50
 	}
-
-	// Metrics validation (optional, but should have at least location)
-	if c.Metrics.Location == "" {
-		return fmt.Errorf("metrics.location is required")
+	if c.Budget.ResetDay == 0 {
+		c.Budget.ResetDay = 1
+	}
+	if c.Budget.MinDuration == 0 {
+		c.Budget.MinDuration = 3
+	}
+	if c.Budget.ProbeDuration == 0 {
+		c.Budget.ProbeDuration = 2
+	}
+	if c.Lock.Wait == 0 {
+		c.Lock.Wait = 5 * time.Minute
 	}
+	if c.Bottleneck.CPUPercent == 0 {
+		c.Bottleneck.CPUPercent = 90
+	}
+	if c.Bottleneck.LinkPercent == 0 {
+		c.Bottleneck.LinkPercent = 90
+	}
+	if c.Metrics.Schema == "" {
+		c.Metrics.Schema = "v1"
+	}
+	if c.StateDir == "" {
+		c.StateDir = defaultStateDir()
+	}
+}
 
-	return nil
+// Warnings returns problems found while loading that did not prevent it, such as deprecated keys
+func (c *Config) Warnings() []string {
+	return c.warnings
 }
 
-// applyEnvOverrides applies environment variable overrides to the config
-func applyEnvOverrides(cfg *Config) {
-	// Prometheus overrides
-	if url := os.Getenv("IBENC_PROMETHEUS_URL"); url != "" {
-		cfg.Prometheus.URL = url
+// StatePath returns the path of a file kept in the state directory
+// Without state_dir the default one is used, never the working directory
+func (c *Config) StatePath(name string) string {
+	dir := c.StateDir
+	if dir == "" {
+		dir = defaultStateDir()
 	}
-	if username := os.Getenv("IBENC_PROMETHEUS_USER"); username != "" {
-		cfg.Prometheus.Username = username
+	return filepath.Join(dir, name)
+}
+
+// defaultStateDir returns the directory systemd creates with StateDirectory=, or /var/lib/ibenc
+func defaultStateDir() string {
+	// Several directories are separated by colons, the first one is ibenc's
+	if dir, _, _ := strings.Cut(os.Getenv("STATE_DIRECTORY"), ":"); dir != "" {
+		return dir
 	}
-	if password := os.Getenv("IBENC_PROMETHEUS_PASS"); password != "" {
-		cfg.Prometheus.Password = password
+	return "/var/lib/ibenc"
+}
+
+// EffectiveTargets returns the targets to measure with defaults from the iperf3 section applied
+// Without a targets list the iperf3 section itself is the only, unnamed, target
+func (c *Config) EffectiveTargets() []TargetConfig {
+	if len(c.Targets) == 0 {
+		return []TargetConfig{{
+			Server:   c.Iperf3.Server,
+			Port:     c.Iperf3.Port,
+			Duration: c.Iperf3.Duration,
+			Parallel: c.Iperf3.Parallel,
+			Protocol: c.Iperf3.Protocol,
+			Bitrate:  c.Iperf3.Bitrate,
+			Labels:   c.Iperf3.Labels,
+
+			AddressFamily: c.Iperf3.AddressFamily,
+			BindAddress:   c.Iperf3.BindAddress,
+			Interface:     c.Iperf3.Interface,
+			WAN:           c.Iperf3.WAN,
+		}}
 	}
 
-	// Iperf3 overrides
-	if server := os.Getenv("IBENC_SERVER"); server != "" {
-		cfg.Iperf3.Server = server
+	targets := make([]TargetConfig, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		if t.Port == 0 {
+			t.Port = c.Iperf3.Port
+		}
+		if t.Duration == 0 {
+			t.Duration = c.Iperf3.Duration
+		}
+		if t.Parallel == 0 {
+			t.Parallel = c.Iperf3.Parallel
+		}
+		if t.Protocol == "" {
+			t.Protocol = c.Iperf3.Protocol
+		}
+		if t.Bitrate == "" {
+			t.Bitrate = c.Iperf3.Bitrate
+		}
+		if t.AddressFamily == "" {
+			t.AddressFamily = c.Iperf3.AddressFamily
+		}
+		if t.BindAddress == "" {
+			t.BindAddress = c.Iperf3.BindAddress
+		}
+		if t.Interface == "" {
+			t.Interface = c.Iperf3.Interface
+		}
+		if t.WAN == "" {
+			t.WAN = c.Iperf3.WAN
+		}
+		t.Labels = mergeLabels(c.Iperf3.Labels, t.Labels)
+		targets = append(targets, t)
 	}
+	return targets
+}
 
-	// Metrics overrides
-	if location := os.Getenv("IBENC_LOCATION"); location != "" {
-		cfg.Metrics.Location = location
+// GetMetricsLabels returns MetricsConfig as metrics.MetricLabels
+func (c *Config) GetMetricsLabels(target TargetConfig) map[string]string {
+	labels := c.CustomLabels(target)
+	labels["location"] = c.Metrics.Location
+	labels["isp_name"] = c.Metrics.ISPName
+	labels["package_name"] = c.Metrics.PackageName
+	if target.Name != "" {
+		labels["target"] = target.Name
 	}
-	if ispName := os.Getenv("IBENC_ISP_NAME"); ispName != "" {
-		cfg.Metrics.ISPName = ispName
+	if target.WAN != "" {
+		labels["wan"] = target.WAN
 	}
-	if packageName := os.Getenv("IBENC_PACKAGE_NAME"); packageName != "" {
-		cfg.Metrics.PackageName = packageName
+	// Like the metric series, only dual-stack runs are told apart by family
+	if target.DualStack {
+		labels["ip_version"] = target.IPVersion()
 	}
+	return labels
 }
 
-// GetMetricsLabels returns MetricsConfig as metrics.MetricLabels
-func (c *Config) GetMetricsLabels() map[string]string {
-	return map[string]string{
-		"location":     c.Metrics.Location,
-		"isp_name":     c.Metrics.ISPName,
-		"package_name": c.Metrics.PackageName,
+// CustomLabels returns metrics.labels merged with the labels of an effective target
+// Target labels win, an empty value removes a label
+func (c *Config) CustomLabels(target TargetConfig) map[string]string {
+	labels := mergeLabels(c.Metrics.Labels, target.Labels)
+	for name, value := range labels {
+		if value == "" {
+			delete(labels, name)
+		}
+	}
+	return labels
+}
+
+// mergeLabels returns a copy of base with overrides applied
+func mergeLabels(base, overrides map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+len(overrides))
+	for name, value := range base {
+		labels[name] = value
+	}
+	for name, value := range overrides {
+		labels[name] = value
 	}
+	return labels
 }
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyEnv maps environment variable names from before the IBENC_SECTION_FIELD
+// scheme to their field paths, the generated name wins when both are set
+var legacyEnv = map[string]string{
+	"IBENC_PROMETHEUS_USER": "prometheus.username",
+	"IBENC_PROMETHEUS_PASS": "prometheus.password",
+	"IBENC_SERVER":          "iperf3.server",
+	"IBENC_LOCATION":        "metrics.location",
+	"IBENC_ISP_NAME":        "metrics.isp_name",
+	"IBENC_PACKAGE_NAME":    "metrics.package_name",
+}
+
+// Flags holds configuration values given on the command line, keyed by field path
+type Flags map[string]string
+
+// RegisterFlags adds a flag for every scalar configuration field, e.g. -iperf3.port
+func RegisterFlags(fs *flag.FlagSet) Flags {
+	flags := Flags{}
+	eachField(reflect.ValueOf(&Config{}).Elem(), nil, func(path string, _ reflect.Value) {
+		usage := fmt.Sprintf("overrides %s (env %s)", path, EnvName(path))
+		fs.Func(path, usage, func(value string) error {
+			flags[path] = value
+			return nil
+		})
+	})
+	return flags
+}
+
+// EnvName returns the environment variable overriding a field path, e.g. IBENC_IPERF3_PORT
+func EnvName(path string) string {
+	return "IBENC_" + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// apply sets the flag values on the config
+func (f Flags) apply(cfg *Config) error {
+	var err error
+	eachField(reflect.ValueOf(cfg).Elem(), nil, func(path string, field reflect.Value) {
+		value, ok := f[path]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := setField(field, value); setErr != nil {
+			err = fmt.Errorf("flag -%s: %w", path, setErr)
+		}
+	})
+	return err
+}
+
+// applyEnvOverrides applies environment variable overrides to the config
+// It returns a warning for every legacy variable name in use
+func applyEnvOverrides(cfg *Config) ([]string, error) {
+	var warnings []string
+	legacy := make(map[string]string)
+	for name, path := range legacyEnv {
+		if value := os.Getenv(name); value != "" {
+			legacy[path] = value
+			warnings = append(warnings, fmt.Sprintf("environment variable %s is deprecated, use %s", name, EnvName(path)))
+		}
+	}
+	sort.Strings(warnings)
+
+	var err error
+	eachField(reflect.ValueOf(cfg).Elem(), nil, func(path string, field reflect.Value) {
+		if err != nil {
+			return
+		}
+		name := EnvName(path)
+		value := os.Getenv(name)
+		if value == "" {
+			value = legacy[path]
+		}
+		if value == "" {
+			return
+		}
+		if setErr := setField(field, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", name, setErr)
+		}
+	})
+	return warnings, err
+}
+
+// eachField walks the settable scalar fields of a config struct by their YAML path
+// Lists are skipped since they have no sensible single-value form
+func eachField(v reflect.Value, path []string, fn func(path string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+		fieldPath := append(append([]string{}, path...), name)
+		switch {
+		case field.Kind() == reflect.Struct:
+			eachField(field, fieldPath, fn)
+		case isScalar(field):
+			fn(strings.Join(fieldPath, "."), field)
+		}
+	}
+}
+
+// isScalar reports whether a field can be set from a single string
+func isScalar(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+		return true
+	case reflect.Map:
+		return field.Type().Key().Kind() == reflect.String && field.Type().Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// setField parses a string into a config field
+// Maps use the key=value,key=value form and replace the configured map
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Int64:
+		if field.Type() != reflect.TypeOf(time.Duration(0)) {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid integer %q", value)
+			}
+			field.SetInt(n)
+			break
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(value, ",") {
+			if pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("invalid key=value pair %q", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), reflect.ValueOf(strings.TrimSpace(v)))
+		}
+		field.Set(m)
+	}
+	return nil
+}
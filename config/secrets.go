@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// credentialPrometheusPassword is the systemd credential name read when no password is configured
+const credentialPrometheusPassword = "prometheus_password"
+
+// redacted replaces secrets in the output of Redacted
+const redacted = "<redacted>"
+
+// envPattern matches ${VAR} and ${VAR:-default}, $${ escapes a literal ${
+var envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces environment variable references in every scalar of the YAML document
+// Comments are left alone so documentation may mention ${VAR} freely
+func interpolateEnv(node *yaml.Node) error {
+	var missing []string
+
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode {
+			n.Value = envPattern.ReplaceAllStringFunc(n.Value, func(match string) string {
+				if match[1] == '$' {
+					return match[1:]
+				}
+
+				groups := envPattern.FindStringSubmatch(match)
+				if value, ok := os.LookupEnv(groups[1]); ok {
+					return value
+				}
+				if groups[2] != "" {
+					return groups[3]
+				}
+
+				missing = append(missing, groups[1])
+				return match
+			})
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables referenced in config are not set: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// resolveSecrets loads secrets kept outside the configuration file
+func (c *Config) resolveSecrets() error {
+	if c.Prometheus.PasswordFile != "" {
+		if c.Prometheus.Password != "" {
+			return fmt.Errorf("prometheus.password and prometheus.password_file are mutually exclusive")
+		}
+		password, err := readSecretFile(c.Prometheus.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("prometheus.password_file: %w", err)
+		}
+		c.Prometheus.Password = password
+	}
+
+	// Fall back to a systemd credential passed with LoadCredential=prometheus_password
+	if c.Prometheus.Password == "" {
+		if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+			password, err := readSecretFile(filepath.Join(dir, credentialPrometheusPassword))
+			if err == nil {
+				c.Prometheus.Password = password
+			} else if !os.IsNotExist(err) {
+				return fmt.Errorf("systemd credential %s: %w", credentialPrometheusPassword, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readSecretFile reads a secret and strips the trailing newline editors add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Redacted returns a copy of the configuration with passwords, tokens and webhook URLs hidden, for display
+func (c *Config) Redacted() *Config {
+	r := *c
+	hide := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+
+	hide(&r.Prometheus.Password)
+	hide(&r.Lock.Token)
+	hide(&r.Controller.Token)
+	hide(&r.API.Token)
+	hide(&r.Grafana.Token)
+	// Webhook URLs of chat services carry their credentials
+	r.Alerts.Webhooks = slices.Clone(r.Alerts.Webhooks)
+	for i := range r.Alerts.Webhooks {
+		hide(&r.Alerts.Webhooks[i].URL)
+	}
+
+	return &r
+}
@@ -0,0 +1,456 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// labelNamePattern is the Prometheus label name syntax
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by ibenc itself and can't be used as custom labels
+var reservedLabels = map[string]bool{
+	"location":       true,
+	"isp_name":       true,
+	"package_name":   true,
+	"direction":      true,
+	"version":        true,
+	"iperf3_version": true,
+	"target":         true,
+	"wan":            true,
+	"ip_version":     true,
+	"side":           true,
+	// Used by histograms and summaries
+	"le":       true,
+	"quantile": true,
+}
+
+// targetNamePattern restricts target names to characters safe in labels and file names
+var targetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Problem is a single configuration error
+type Problem struct {
+	Path    string // YAML path, e.g. alerts.rules[0].name
+	Line    int    // line in the config file, 0 when unknown
+	Message string
+}
+
+// String formats the problem with its line number when known
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return p.Message
+}
+
+// ValidationError holds every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error joins all problems into a single line
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		messages = append(messages, p.String())
+	}
+	if len(messages) == 1 {
+		return messages[0]
+	}
+	return fmt.Sprintf("%d configuration problems: %s", len(messages), strings.Join(messages, "; "))
+}
+
+// validator collects problems while validating
+type validator struct {
+	source   *yaml.Node
+	problems []Problem
+}
+
+// check records a problem at path unless ok holds
+func (v *validator) check(ok bool, path, format string, args ...any) {
+	if ok {
+		return
+	}
+	v.problems = append(v.problems, Problem{
+		Path:    path,
+		Line:    lineOf(v.source, path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks that all required fields are set and reports every problem found
+func (c *Config) Validate() error {
+	v := &validator{source: c.source}
+	v.problems = append(v.problems, c.unknownKeys...)
+
+	// Prometheus validation, optional when metrics go to the textfile collector only
+	if c.Prometheus.URL != "" || c.Textfile.Directory == "" {
+		v.check(c.Prometheus.URL != "", "prometheus.url", "prometheus.url is required (or textfile.directory)")
+		v.check(c.Prometheus.Username != "", "prometheus.username", "prometheus.username is required")
+		v.check(c.Prometheus.Password != "", "prometheus.password", "prometheus.password is required (or prometheus.password_file)")
+	}
+
+	// Iperf3 validation, the server may come from the targets instead
+	v.check(c.Iperf3.Server != "" || len(c.Targets) > 0, "iperf3.server", "iperf3.server is required (or targets)")
+	v.check(c.Iperf3.Port > 0 && c.Iperf3.Port <= 65535, "iperf3.port", "iperf3.port must be between 1 and 65535")
+	v.check(c.Iperf3.Duration > 0, "iperf3.duration", "iperf3.duration must be greater than 0")
+	v.checkOptions("iperf3", c.Iperf3.Parallel, c.Iperf3.Protocol, c.Iperf3.AddressFamily, c.Iperf3.BindAddress)
+	v.checkBindAddress("iperf3", c.Iperf3.AddressFamily, c.Iperf3.BindAddress)
+	v.checkWAN("iperf3", c.Iperf3.WAN)
+
+	// Targets validation (optional)
+	names := make(map[string]bool)
+	for i, t := range c.Targets {
+		path := fmt.Sprintf("targets[%d]", i)
+		v.check(targetNamePattern.MatchString(t.Name), path+".name", "%s.name is required and may only contain letters, digits, '.', '_' and '-'", path)
+		v.check(!names[t.Name], path+".name", "%s.name %q is used by another target", path, t.Name)
+		names[t.Name] = true
+		v.check(len(t.ServerList()) > 0, path+".server", "%s.server or %s.servers is required", path, path)
+		v.check(t.Port >= 0 && t.Port <= 65535, path+".port", "%s.port must be between 1 and 65535", path)
+		v.check(t.Duration >= 0, path+".duration", "%s.duration must be greater than 0", path)
+		v.check(t.Interval >= 0, path+".interval", "%s.interval must not be negative", path)
+		v.checkOptions(path, t.Parallel, t.Protocol, t.AddressFamily, t.BindAddress)
+		// Targets inherit both from iperf3, where they were checked if the target sets neither
+		if t.AddressFamily != "" || t.BindAddress != "" {
+			v.checkBindAddress(path, cmp.Or(t.AddressFamily, c.Iperf3.AddressFamily), cmp.Or(t.BindAddress, c.Iperf3.BindAddress))
+		}
+		v.checkWAN(path, t.WAN)
+		v.checkLabels(path+".labels", t.Labels)
+	}
+
+	// Metrics validation (optional, but should have at least location)
+	v.check(c.Metrics.Location != "", "metrics.location", "metrics.location is required")
+
+	// Custom labels validation (optional)
+	v.checkLabels("metrics.labels", c.Metrics.Labels)
+	v.checkLabels("iperf3.labels", c.Iperf3.Labels)
+
+	// Plan speeds validation (optional)
+	v.check(c.Metrics.PlanDownloadMbps >= 0, "metrics.plan_download_mbps", "metrics.plan_download_mbps must not be negative")
+	v.check(c.Metrics.PlanUploadMbps >= 0, "metrics.plan_upload_mbps", "metrics.plan_upload_mbps must not be negative")
+
+	// Histogram validation (optional)
+	v.check(c.Metrics.Histograms == "" || c.Metrics.Histograms == "classic" || c.Metrics.Histograms == "native" || c.Metrics.Histograms == "both",
+		"metrics.histograms", "metrics.histograms must be classic, native or both")
+
+	// Schema validation
+	v.check(c.Metrics.Schema == "" || c.Metrics.Schema == "v1" || c.Metrics.Schema == "v2" || c.Metrics.Schema == "both",
+		"metrics.schema", "metrics.schema must be v1, v2 or both")
+
+	// Cross traffic validation (optional)
+	v.check(c.CrossTraffic.ThresholdMbps >= 0, "cross_traffic.threshold_mbps", "cross_traffic.threshold_mbps must not be negative")
+
+	// Server selection validation (optional)
+	sel := c.ServerSelection
+	v.check(sel.Latitude >= -90 && sel.Latitude <= 90, "server_selection.latitude", "server_selection.latitude must be between -90 and 90")
+	v.check(sel.Longitude >= -180 && sel.Longitude <= 180, "server_selection.longitude", "server_selection.longitude must be between -180 and 180")
+	v.check(sel.Candidates >= 0, "server_selection.candidates", "server_selection.candidates must not be negative")
+	v.check(sel.Count >= 0, "server_selection.count", "server_selection.count must not be negative")
+	v.check(sel.TTL >= 0, "server_selection.ttl", "server_selection.ttl must not be negative")
+	v.check(sel.DegradePercent >= 0 && sel.DegradePercent <= 100, "server_selection.degrade_percent", "server_selection.degrade_percent must be between 0 and 100")
+
+	// Budget validation (optional)
+	v.check(c.Budget.MonthlyGB >= 0, "budget.monthly_gb", "budget.monthly_gb must not be negative")
+	v.check(c.Budget.ResetDay >= 0 && c.Budget.ResetDay <= 28, "budget.reset_day", "budget.reset_day must be between 1 and 28")
+	v.check(c.Budget.MinDuration >= 0, "budget.min_duration", "budget.min_duration must not be negative")
+	v.check(c.Budget.ProbeDuration >= 0, "budget.probe_duration", "budget.probe_duration must not be negative")
+
+	// Lock validation (optional)
+	v.check(c.Lock.Wait >= 0, "lock.wait", "lock.wait must not be negative")
+	v.check(c.Lock.Server == "" || strings.HasPrefix(c.Lock.Server, "http://") || strings.HasPrefix(c.Lock.Server, "https://"),
+		"lock.server", "lock.server must be an http:// or https:// URL")
+
+	// Controller validation (optional)
+	v.check(c.Controller.URL == "" || strings.HasPrefix(c.Controller.URL, "http://") || strings.HasPrefix(c.Controller.URL, "https://"),
+		"controller.url", "controller.url must be an http:// or https:// URL")
+
+	// Grafana validation (optional)
+	v.check(c.Grafana.URL == "" || strings.HasPrefix(c.Grafana.URL, "http://") || strings.HasPrefix(c.Grafana.URL, "https://"),
+		"grafana.url", "grafana.url must be an http:// or https:// URL")
+
+	// API validation (optional)
+	v.check(c.API.Listen == "" || c.API.Token != "", "api.token", "api.token is required when api.listen is set")
+
+	// Bottleneck validation (optional)
+	v.check(c.Bottleneck.CPUPercent >= 0, "bottleneck.cpu_percent", "bottleneck.cpu_percent must not be negative")
+	v.check(c.Bottleneck.LinkPercent >= 0 && c.Bottleneck.LinkPercent <= 100, "bottleneck.link_percent", "bottleneck.link_percent must be between 0 and 100")
+
+	// Daemon validation (optional)
+	v.check(c.Daemon.Interval >= 0, "daemon.interval", "daemon.interval must not be negative")
+
+	// Alerts validation (optional)
+	for i, rule := range c.Alerts.Rules {
+		path := fmt.Sprintf("alerts.rules[%d]", i)
+		v.check(rule.Name != "", path+".name", "%s.name is required", path)
+		switch rule.Field {
+		case "download_mbps", "upload_mbps", "latency_ms", "jitter_ms", "packet_loss_percent":
+		default:
+			v.check(false, path+".field", "%s.field must be one of download_mbps, upload_mbps, latency_ms, jitter_ms, packet_loss_percent", path)
+		}
+		switch rule.Op {
+		case "<", "<=", ">", ">=":
+		default:
+			v.check(false, path+".op", "%s.op must be one of <, <=, >, >=", path)
+		}
+		v.check(rule.For >= 0, path+".for", "%s.for must not be negative", path)
+	}
+	for i, webhook := range c.Alerts.Webhooks {
+		path := fmt.Sprintf("alerts.webhooks[%d]", i)
+		v.check(webhook.URL != "", path+".url", "%s.url is required", path)
+		switch webhook.Format {
+		case "", "json", "slack", "discord", "teams":
+		default:
+			v.check(false, path+".format", "%s.format must be one of json, slack, discord, teams", path)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		// Report in file order, problems without a line last
+		sort.SliceStable(v.problems, func(i, j int) bool {
+			a, b := v.problems[i].Line, v.problems[j].Line
+			return a != 0 && (b == 0 || a < b)
+		})
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// checkOptions validates the iperf3 client options shared by the iperf3 section and targets
+func (v *validator) checkOptions(path string, parallel int, protocol, addressFamily, bindAddress string) {
+	v.check(parallel >= 0, path+".parallel", "%s.parallel must not be negative", path)
+	v.check(protocol == "" || protocol == "tcp" || protocol == "udp", path+".protocol", "%s.protocol must be tcp or udp", path)
+	switch addressFamily {
+	case "", "ipv4", "ipv6", "both":
+	default:
+		v.check(false, path+".address_family", "%s.address_family must be one of ipv4, ipv6, both", path)
+	}
+	v.check(bindAddress == "" || net.ParseIP(bindAddress) != nil, path+".bind_address", "%s.bind_address must be an IP address", path)
+}
+
+// checkWAN validates a wan name, it names the lock, lease and budget state of the uplink
+func (v *validator) checkWAN(path, wan string) {
+	v.check(wan == "" || targetNamePattern.MatchString(wan), path+".wan", "%s.wan may only contain letters, digits, '.', '_' and '-'", path)
+}
+
+// checkBindAddress checks that bind_address doesn't contradict address_family
+func (v *validator) checkBindAddress(path, addressFamily, bindAddress string) {
+	ip := net.ParseIP(bindAddress)
+	if ip == nil {
+		// Unset, or reported by checkOptions
+		return
+	}
+
+	switch {
+	case addressFamily == "both":
+		v.check(false, path+".bind_address", "%s.bind_address can't be combined with address_family both", path)
+	case addressFamily == "ipv4" && ip.To4() == nil:
+		v.check(false, path+".bind_address", "%s.bind_address %s is not an IPv4 address but address_family is ipv4", path, bindAddress)
+	case addressFamily == "ipv6" && ip.To4() != nil:
+		v.check(false, path+".bind_address", "%s.bind_address %s is not an IPv6 address but address_family is ipv6", path, bindAddress)
+	}
+}
+
+// checkLabels validates custom label names against the Prometheus rules
+func (v *validator) checkLabels(path string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		labelPath := path + "." + name
+		switch {
+		case !labelNamePattern.MatchString(name):
+			v.check(false, labelPath, "%s: label name %q must match [a-zA-Z_][a-zA-Z0-9_]*", path, name)
+		case strings.HasPrefix(name, "__"):
+			v.check(false, labelPath, "%s: label names starting with __ are reserved", path)
+		case reservedLabels[name]:
+			v.check(false, labelPath, "%s: label %q is set by ibenc", path, name)
+		}
+	}
+}
+
+// checkKeys walks the document along the Config struct and returns every key that matches no
+// setting with its full path, e.g. targets[1].isp-name, and a warning for every superseded key
+func checkKeys(node *yaml.Node) (unknown []Problem, warnings []string) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		node = node.Content[0]
+	}
+
+	w := &keyWalker{}
+	w.walk(node, reflect.TypeOf(Config{}), "")
+
+	// The single server layout was replaced by targets, which don't fall back to its server
+	noop := func(*yaml.Node) {}
+	if walkPath(node, "iperf3.server", noop) && walkPath(node, "targets", noop) {
+		w.warnings = append(w.warnings, fmt.Sprintf("line %d: iperf3.server is superseded by targets and ignored, set the server of each target instead", lineOf(node, "iperf3.server")))
+	}
+
+	return w.unknown, w.warnings
+}
+
+// keyWalker collects the findings of checkKeys
+type keyWalker struct {
+	unknown  []Problem
+	warnings []string
+}
+
+// walk checks the keys of node against type t, path locates the node in the document
+func (w *keyWalker) walk(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			// Merge keys (<<: *anchor) add their mapping's keys to this one
+			if key.Tag == "!!merge" {
+				w.walk(value, t, path)
+				continue
+			}
+
+			keyPath := joinPath(path, key.Value)
+			field, ok := fields[key.Value]
+			if !ok {
+				w.unknown = append(w.unknown, Problem{
+					Path:    keyPath,
+					Line:    key.Line,
+					Message: fmt.Sprintf("unknown key %s", keyPath),
+				})
+				continue
+			}
+			w.walk(value, field.Type, keyPath)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			w.walk(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			w.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+	// Anything else is a value, type errors are left to the regular decode
+}
+
+// yamlFields returns the fields of a struct by their YAML key
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// joinPath appends a key to a dotted path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lineOf returns the line of the value at path, or of its closest existing parent
+func lineOf(node *yaml.Node, path string) int {
+	if node == nil {
+		return 0
+	}
+
+	line := 0
+	walkPath(node, path, func(n *yaml.Node) {
+		line = n.Line
+	})
+	return line
+}
+
+// walkPath follows a path such as alerts.rules[0].name through the document,
+// calling visit for every node found along the way, and reports whether the whole path exists
+func walkPath(node *yaml.Node, path string, visit func(*yaml.Node)) bool {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, segment := range pathSegments(path) {
+		var next *yaml.Node
+		switch {
+		case segment.index >= 0 && node.Kind == yaml.SequenceNode && segment.index < len(node.Content):
+			next = node.Content[segment.index]
+		case segment.index < 0 && node.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment.key {
+					next = node.Content[i+1]
+					// Report the key's line, a value may start on the next line
+					visit(node.Content[i])
+					break
+				}
+			}
+			if next != nil {
+				node = next
+				continue
+			}
+		}
+		if next == nil {
+			return false
+		}
+		visit(next)
+		node = next
+	}
+	return true
+}
+
+// pathSegment is a mapping key or a sequence index
+type pathSegment struct {
+	key   string
+	index int // -1 for mapping keys
+}
+
+// pathSegments splits alerts.rules[0].name into alerts, rules, [0], name
+func pathSegments(path string) []pathSegment {
+	var segments []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			segments = append(segments, pathSegment{key: key, index: -1})
+		}
+		for rest != "" {
+			var index string
+			index, rest, _ = strings.Cut(rest, "]")
+			n, err := strconv.Atoi(index)
+			if err != nil {
+				break
+			}
+			segments = append(segments, pathSegment{index: n})
+			rest = strings.TrimPrefix(rest, "[")
+		}
+	}
+	return segments
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long a burst of events, e.g. an editor saving or a ConfigMap update, may last
+const watchSettle = 100 * time.Millisecond
+
+// Watch calls onChange when the configuration file is written, created or replaced
+// The parent directory is watched so editors that save by renaming are noticed too, as are
+// symlink swaps such as Kubernetes replacing the ..data link of a mounted ConfigMap
+func Watch(ctx context.Context, configPath string, onChange func()) error {
+	configPath, err := expandPath(configPath)
+	if err != nil {
+		return err
+	}
+	configPath = filepath.Clean(configPath)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// A symlinked file is written where it points to, watch that directory too
+	target, _ := filepath.EvalSymlinks(configPath)
+	if target != "" && filepath.Dir(target) != filepath.Dir(configPath) {
+		watcher.Add(filepath.Dir(target))
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-settle:
+				settle = nil
+				onChange()
+			case <-watcher.Errors:
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+
+				// A swapped symlink changes the file's target without an event for the file itself
+				resolved, _ := filepath.EvalSymlinks(configPath)
+				name := filepath.Clean(event.Name)
+				if name != configPath && name != target && resolved == target {
+					continue
+				}
+				target = resolved
+				if settle == nil {
+					settle = time.After(watchSettle)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
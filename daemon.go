@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/config"
+	"ibenc/fleet"
+	"ibenc/iperf3"
+	"ibenc/remote"
+)
+
+// defaultInterval is used when daemon.interval is not configured
+const defaultInterval = 15 * time.Minute
+
+// maxQueuedBatches bounds the metrics kept while the remote write endpoint is unreachable
+const maxQueuedBatches = 96
+
+// daemon runs tests on a schedule and reloads its configuration on SIGHUP or file change
+type daemon struct {
+	configPath string
+	overrides  config.Flags
+	config     atomic.Pointer[config.Config]
+
+	// Metric batches waiting to be sent, kept across configuration reloads
+	queue [][]*io_prometheus_client.MetricFamily
+
+	// Fleet controller agent, nil without controller.url
+	agent         *fleet.Agent
+	mu            sync.Mutex
+	overlays      [][]byte // configuration pushed by the controller
+	configVersion string
+	offset        atomic.Int64 // position of runs within the interval in seconds, set by the controller
+
+	// On-demand tests from the API, run by the loop between scheduled tests
+	tests chan testJob
+
+	// Last time old results were removed from the history
+	lastPrune time.Time
+}
+
+// runDaemon runs ibenc as a long-running service and returns the process exit code
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	configPath := fs.String("config", "ibenc.yaml", "path to configuration file")
+	overrides := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfigWithOverrides(*configPath, overrides)
+	if err != nil {
+		log.Printf("Failed to load configuration: %v\n", err)
+		return 1
+	}
+	for _, warning := range cfg.Warnings() {
+		log.Printf("Warning: %s", warning)
+	}
+
+	d := &daemon{configPath: *configPath, overrides: overrides, tests: make(chan testJob)}
+	d.config.Store(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP and file changes both request a reload
+	reload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			requestReload()
+		}
+	}()
+
+	if err := config.Watch(ctx, *configPath, requestReload); err != nil {
+		log.Printf("Warning: not watching %s for changes, reload with SIGHUP: %v", *configPath, err)
+	}
+
+	if cfg.Controller.URL != "" {
+		d.startAgent(ctx, cfg, requestReload)
+	}
+	if cfg.API.Listen != "" {
+		d.startAPI(ctx, cfg)
+	}
+
+	log.Printf("ibenc %s daemon started with %d target(s)\n", version, len(d.config.Load().EffectiveTargets()))
+	d.loop(ctx, reload)
+	log.Println("ibenc daemon stopped")
+
+	return 0
+}
+
+// loop runs each target whenever its interval elapses until the context is cancelled
+// Only one target runs at a time so tests never compete for the link
+func (d *daemon) loop(ctx context.Context, reload <-chan struct{}) {
+	lastRun := make(map[string]time.Time)
+	offset := d.offset.Load()
+
+	for {
+		// Recomputed every iteration so reloaded targets and intervals apply to the next run
+		cfg := d.config.Load()
+		if d.agent != nil {
+			alignSchedule(cfg, lastRun, time.Duration(offset)*time.Second)
+		}
+		target, due := nextTarget(cfg, lastRun)
+		timer := time.NewTimer(time.Until(due))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.flush(cfg)
+			return
+		case <-reload:
+			d.reload()
+			// A new position from the controller reschedules every target
+			if o := d.offset.Load(); o != offset {
+				offset = o
+				clear(lastRun)
+			}
+		case <-timer.C:
+			lastRun[target.Name] = time.Now()
+			d.runOnce(cfg, target, nil)
+		case job := <-d.tests:
+			job.started()
+			job.done <- d.runOnce(cfg, job.target, job.onInterval)
+		}
+
+		timer.Stop()
+	}
+}
+
+// nextTarget returns the target that is due first, earlier targets win ties
+func nextTarget(cfg *config.Config, lastRun map[string]time.Time) (config.TargetConfig, time.Time) {
+	targets := cfg.EffectiveTargets()
+
+	next := targets[0]
+	nextDue := lastRun[next.Name].Add(interval(cfg, next))
+	for _, target := range targets[1:] {
+		if due := lastRun[target.Name].Add(interval(cfg, target)); due.Before(nextDue) {
+			next, nextDue = target, due
+		}
+	}
+
+	return next, nextDue
+}
+
+// runOnce runs the tests of a target, sends queued metrics and returns the outcome of each test
+func (d *daemon) runOnce(cfg *config.Config, target config.TargetConfig, onInterval func(iperf3.Interval)) []targetRun {
+	metricsData, runs, err := runTarget(cfg, target, onInterval)
+	if err != nil {
+		log.Printf("Test failed: %v\n", err)
+	}
+	if d.agent != nil {
+		d.reportResults(runs)
+	}
+	d.recordHistory(cfg, runs)
+
+	d.queue = append(d.queue, metricsData)
+	if len(d.queue) > maxQueuedBatches {
+		log.Printf("Warning: metrics queue full, dropping %d oldest batches", len(d.queue)-maxQueuedBatches)
+		d.queue = d.queue[len(d.queue)-maxQueuedBatches:]
+	}
+
+	d.flush(cfg)
+	return runs
+}
+
+// flush sends queued batches in order, stopping at the first failure that may pass on the next run
+// Rejected batches are dropped, retrying them would block every newer batch forever
+func (d *daemon) flush(cfg *config.Config) {
+	sent := 0
+	for len(d.queue) > 0 {
+		err := sendMetrics(cfg, d.queue[0])
+		if err != nil && retryable(err) {
+			log.Printf("Failed to send metrics, %d batches queued for the next run: %v\n", len(d.queue), err)
+			break
+		}
+		if err != nil {
+			log.Printf("Warning: dropping metrics batch that can't be sent: %v", err)
+		} else {
+			sent++
+		}
+		d.queue = d.queue[1:]
+	}
+
+	if sent > 0 {
+		log.Println("Metrics sent successfully!")
+	}
+}
+
+// retryable reports whether a send error may pass later, such as an unreachable or overloaded
+// endpoint, rather than a rejected request or a textfile write error
+func retryable(err error) bool {
+	var statusErr *remote.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// reload re-reads the configuration file, keeping the current one if the new one is invalid
+func (d *daemon) reload() {
+	d.mu.Lock()
+	overlays := d.overlays
+	d.mu.Unlock()
+
+	cfg, err := config.LoadConfigWithOverlays(d.configPath, d.overrides, overlays)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping previous configuration: %v\n", err)
+		return
+	}
+
+	for _, warning := range cfg.Warnings() {
+		log.Printf("Warning: %s", warning)
+	}
+	d.config.Store(cfg)
+	log.Printf("Configuration reloaded from %s with %d target(s)\n", d.configPath, len(cfg.EffectiveTargets()))
+}
+
+// interval returns the test interval of a target
+func interval(cfg *config.Config, target config.TargetConfig) time.Duration {
+	if target.Interval > 0 {
+		return target.Interval
+	}
+	if cfg.Daemon.Interval > 0 {
+		return cfg.Daemon.Interval
+	}
+	return defaultInterval
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/budget"
+	"ibenc/config"
+	"ibenc/iperf3"
+	"ibenc/metrics"
+)
+
+// budgetPlan is the outcome of fitting a test into the data budget
+type budgetPlan struct {
+	tracker  *budget.Tracker
+	duration int  // test duration in seconds
+	skip     bool // not even the shortest test fits
+}
+
+// planBudget chooses the test duration that keeps a target within the monthly data budget
+// A short probe estimates the transfer rate first, nil means the target runs without a budget
+func planBudget(cfg *config.Config, target config.TargetConfig) *budgetPlan {
+	if cfg.Budget.MonthlyGB <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	tracker, err := budget.NewTracker(budget.Config{
+		MonthlyBytes: int64(cfg.Budget.MonthlyGB * 1e9),
+		ResetDay:     cfg.Budget.ResetDay,
+		StatePath:    cfg.StatePath(budgetStateFile(target)),
+	}, now)
+	if err != nil {
+		log.Printf("Warning: data budget disabled: %v", err)
+		return nil
+	}
+
+	plan := &budgetPlan{tracker: tracker, duration: target.Duration}
+	allowance := float64(tracker.Allowance(now, interval(cfg, target), budgetSharers(cfg, target)))
+
+	// The rate of the last probe tells whether another probe fits at all
+	probeDuration := cfg.Budget.ProbeDuration
+	if tracker.Remaining() == 0 || tracker.BytesPerSecond()*float64(probeDuration) > allowance {
+		plan.skip = true
+		return plan
+	}
+
+	endpoints := targetEndpoints(cfg, target)
+	if len(endpoints) == 0 {
+		return plan
+	}
+	opts := targetOptions(cfg, target, endpoints[0])
+	opts.Duration = probeDuration
+	opts.MonitorInterface = ""
+
+	log.Printf("Probing %s:%d for %ds to fit the data budget\n", endpoints[0].host, endpoints[0].port, probeDuration)
+	probe, err := iperf3.RunBothTestsWithOptions(opts)
+	probeBytes := probe.DownloadBytes + probe.UploadBytes
+	tracker.Add(probeBytes)
+	if err != nil || probeBytes == 0 {
+		// The test will most likely fail as well, keep it short
+		plan.duration = min(target.Duration, cfg.Budget.MinDuration)
+		return plan
+	}
+
+	rate := float64(probeBytes) / float64(probeDuration)
+	tracker.SetBytesPerSecond(rate)
+	plan.duration = min(target.Duration, int((allowance-float64(probeBytes))/rate))
+	if plan.duration < cfg.Budget.MinDuration {
+		plan.skip = true
+	}
+
+	return plan
+}
+
+// record counts the bytes of a test and saves the usage
+func (p *budgetPlan) record(result *iperf3.TestResult) {
+	if result != nil {
+		p.tracker.Add(result.DownloadBytes + result.UploadBytes)
+	}
+	if err := p.tracker.Save(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// metrics exports the budget state after the run
+func (p *budgetPlan) metrics(labels metrics.MetricLabels) []*io_prometheus_client.MetricFamily {
+	return metrics.ExportBudgetMetrics(metrics.BudgetStats{
+		UsedBytes:      p.tracker.Used(),
+		RemainingBytes: p.tracker.Remaining(),
+		Skipped:        p.skip,
+		Duration:       p.duration,
+	}, labels)
+}
+
+// budgetSharers returns the number of tests per interval drawing on the budget of a target's uplink
+func budgetSharers(cfg *config.Config, target config.TargetConfig) int {
+	n := 0
+	for _, t := range cfg.EffectiveTargets() {
+		if t.WAN == target.WAN {
+			n += len(t.AddressFamilies())
+		}
+	}
+	return n
+}
+
+// budgetStateFile returns the name of the data usage file of a target's uplink
+func budgetStateFile(target config.TargetConfig) string {
+	if target.WAN != "" {
+		return "budget-" + target.WAN + ".json"
+	}
+	return "budget.json"
+}
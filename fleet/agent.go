@@ -0,0 +1,103 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Agent reports to a controller and receives its assignment
+type Agent struct {
+	url   string
+	token string
+	id    string
+
+	httpClient *http.Client
+}
+
+// NewAgent creates an agent with the given ID
+func NewAgent(controllerURL, token, id string) *Agent {
+	return &Agent{
+		url:        strings.TrimSuffix(controllerURL, "/"),
+		token:      token,
+		id:         id,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run sends a heartbeat every HeartbeatInterval until the context is cancelled
+// status describes the agent at the time of each heartbeat, onAssignment receives every answer
+func (a *Agent) Run(ctx context.Context, status func() Heartbeat, onAssignment func(*Assignment)) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		assignment, err := a.Heartbeat(status())
+		if err != nil {
+			log.Printf("Warning: controller heartbeat failed: %v", err)
+		} else {
+			onAssignment(assignment)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Heartbeat reports to the controller and returns the agent's assignment
+func (a *Agent) Heartbeat(hb Heartbeat) (*Assignment, error) {
+	var assignment Assignment
+	if err := a.post("heartbeat", hb, &assignment); err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// SendResult reports a test result to the controller
+func (a *Agent) SendResult(result Result) error {
+	return a.post("results", result, nil)
+}
+
+// post sends a JSON request to an endpoint of the agent and decodes the answer into out
+func (a *Agent) post(endpoint string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.url+"/v1/agents/"+url.PathEscape(a.id)+"/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("controller returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to parse controller response: %w", err)
+		}
+	}
+	return nil
+}
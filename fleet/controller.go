@@ -0,0 +1,309 @@
+package fleet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"ibenc/config"
+)
+
+// maxResults is the number of results kept in memory per agent
+const maxResults = 100
+
+// controllerFile is the layout of the controller's configuration file
+type controllerFile struct {
+	Defaults yaml.Node            `yaml:"defaults"` // ibenc config overlay for every agent
+	Agents   map[string]yaml.Node `yaml:"agents"`   // ibenc config overlay per agent ID
+}
+
+// agentState is what the controller knows about an agent
+type agentState struct {
+	heartbeat Heartbeat
+	lastSeen  time.Time
+	offset    int
+	results   []Result
+}
+
+// Controller hands out configuration and schedules to agents and collects their results
+type Controller struct {
+	token       string // shared secret agents send as a bearer token, empty disables auth
+	resultsFile string // JSON lines file results are appended to, empty keeps them in memory only
+
+	mu       sync.Mutex
+	defaults []byte
+	overlays map[string][]byte
+	agents   map[string]*agentState
+}
+
+// NewController creates a controller
+func NewController(token, resultsFile string) *Controller {
+	return &Controller{
+		token:       token,
+		resultsFile: resultsFile,
+		overlays:    make(map[string][]byte),
+		agents:      make(map[string]*agentState),
+	}
+}
+
+// Load reads the agent configuration overlays from the controller's file
+// Every overlay must decode as an ibenc configuration, so typos are caught before agents see them
+func (c *Controller) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read controller config: %w", err)
+	}
+	var file controllerFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse controller config: %w", err)
+	}
+
+	defaults, err := encodeOverlay("defaults", &file.Defaults)
+	if err != nil {
+		return err
+	}
+	overlays := make(map[string][]byte, len(file.Agents))
+	for id, node := range file.Agents {
+		if overlays[id], err = encodeOverlay("agents."+id, &node); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.defaults, c.overlays = defaults, overlays
+	c.mu.Unlock()
+	return nil
+}
+
+// encodeOverlay checks an overlay against the ibenc configuration and returns it as YAML
+func encodeOverlay(name string, node *yaml.Node) ([]byte, error) {
+	if node.Kind == 0 {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config.Config{}); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// Handler returns the HTTP API of the controller
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/agents", c.authorized(c.handleAgents))
+	mux.HandleFunc("POST /v1/agents/{id}/heartbeat", c.authorized(c.handleHeartbeat))
+	mux.HandleFunc("POST /v1/agents/{id}/results", c.authorized(c.handleResult))
+	mux.HandleFunc("GET /v1/agents/{id}/results", c.authorized(c.handleResults))
+	return mux
+}
+
+// authorized rejects requests without the shared token
+func (c *Controller) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.token != "" {
+			want := "Bearer " + c.token
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleHeartbeat registers an agent or records that it is alive, and returns its assignment
+func (c *Controller) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var hb Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agent := c.agents[id]
+	if agent == nil {
+		log.Printf("Agent %s registered from %s\n", id, hb.Hostname)
+		agent = &agentState{}
+		c.agents[id] = agent
+	}
+	agent.heartbeat = hb
+	agent.lastSeen = time.Now()
+	c.stagger()
+
+	var overlays []string
+	for _, overlay := range [][]byte{c.defaults, c.overlays[id]} {
+		if len(overlay) > 0 {
+			overlays = append(overlays, string(overlay))
+		}
+	}
+	writeJSON(w, http.StatusOK, Assignment{
+		ConfigVersion: configVersion(overlays),
+		Overlays:      overlays,
+		OffsetSeconds: agent.offset,
+	})
+}
+
+// stagger spreads the runs of online agents sharing a server evenly over their interval
+func (c *Controller) stagger() {
+	groups := make(map[string][]string)
+	for id, agent := range c.agents {
+		if time.Since(agent.lastSeen) > offlineAfter {
+			continue
+		}
+		key := ""
+		if len(agent.heartbeat.Servers) > 0 {
+			key = agent.heartbeat.Servers[0]
+		}
+		groups[key] = append(groups[key], id)
+	}
+
+	for _, ids := range groups {
+		sort.Strings(ids)
+		for i, id := range ids {
+			agent := c.agents[id]
+			agent.offset = i * agent.heartbeat.IntervalSeconds / len(ids)
+		}
+	}
+}
+
+// configVersion identifies a set of overlays so agents only reload when it changes
+func configVersion(overlays []string) string {
+	h := sha256.New()
+	for _, overlay := range overlays {
+		h.Write([]byte(overlay))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// handleResult stores a test result of an agent
+func (c *Controller) handleResult(w http.ResponseWriter, r *http.Request) {
+	var result Result
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+
+	c.mu.Lock()
+	agent := c.agents[id]
+	if agent == nil {
+		c.mu.Unlock()
+		http.Error(w, "agent not registered", http.StatusNotFound)
+		return
+	}
+	agent.lastSeen = time.Now()
+	agent.results = append(agent.results, result)
+	if len(agent.results) > maxResults {
+		agent.results = agent.results[len(agent.results)-maxResults:]
+	}
+	c.mu.Unlock()
+
+	if c.resultsFile != "" {
+		if err := appendResult(c.resultsFile, id, result); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendResult adds a result to the JSON lines results file
+func appendResult(path, id string, result Result) error {
+	line, err := json.Marshal(struct {
+		Agent string `json:"agent"`
+		Result
+	}{id, result})
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open results file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write results file: %w", err)
+	}
+	return nil
+}
+
+// handleAgents lists the agents with their health, sorted by ID
+func (c *Controller) handleAgents(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	agents := make([]AgentStatus, 0, len(c.agents))
+	for id, agent := range c.agents {
+		agents = append(agents, agent.status(id))
+	}
+	c.mu.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	writeJSON(w, http.StatusOK, agents)
+}
+
+// status returns the health of an agent
+func (a *agentState) status(id string) AgentStatus {
+	status := AgentStatus{
+		ID:            id,
+		Hostname:      a.heartbeat.Hostname,
+		Version:       a.heartbeat.Version,
+		Status:        "ok",
+		LastSeen:      a.lastSeen,
+		OffsetSeconds: a.offset,
+	}
+	if len(a.results) > 0 {
+		last := a.results[len(a.results)-1]
+		status.LastResult = &last
+		if !last.Success {
+			status.Status = "failing"
+		}
+	}
+	if time.Since(a.lastSeen) > offlineAfter {
+		status.Status = "offline"
+	}
+	return status
+}
+
+// handleResults returns the recent results of an agent, oldest first
+func (c *Controller) handleResults(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	agent := c.agents[r.PathValue("id")]
+	var results []Result
+	if agent != nil {
+		results = append([]Result{}, agent.results...)
+	}
+	c.mu.Unlock()
+
+	if agent == nil {
+		http.Error(w, "agent not registered", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package fleet
+
+import "time"
+
+// HeartbeatInterval is how often agents report to the controller and pick up config changes
+const HeartbeatInterval = time.Minute
+
+// offlineAfter is how long an agent may miss heartbeats before it counts as offline
+const offlineAfter = 3 * HeartbeatInterval
+
+// Heartbeat is what an agent reports about itself
+type Heartbeat struct {
+	Hostname        string   `json:"hostname"`
+	Version         string   `json:"version"`
+	Servers         []string `json:"servers"`          // iperf3 servers the agent tests against
+	IntervalSeconds int      `json:"interval_seconds"` // time between runs of the agent
+}
+
+// Assignment is the controller's answer to a heartbeat
+type Assignment struct {
+	ConfigVersion string   `json:"config_version"`
+	Overlays      []string `json:"overlays"`       // YAML applied over the agent's config file in order
+	OffsetSeconds int      `json:"offset_seconds"` // position of the agent's runs within its interval
+}
+
+// Result summarises a test of an agent
+type Result struct {
+	Target            string    `json:"target,omitempty"`
+	IPVersion         string    `json:"ip_version,omitempty"`
+	Time              time.Time `json:"time"`
+	Success           bool      `json:"success"`
+	Error             string    `json:"error,omitempty"`
+	DownloadMbps      float64   `json:"download_mbps"`
+	UploadMbps        float64   `json:"upload_mbps"`
+	LatencyMs         float64   `json:"latency_ms"`
+	JitterMs          float64   `json:"jitter_ms"`
+	PacketLossPercent float64   `json:"packet_loss_percent"`
+}
+
+// AgentStatus is the health of an agent as seen by the controller
+type AgentStatus struct {
+	ID            string    `json:"id"`
+	Hostname      string    `json:"hostname"`
+	Version       string    `json:"version"`
+	Status        string    `json:"status"` // ok, failing or offline
+	LastSeen      time.Time `json:"last_seen"`
+	OffsetSeconds int       `json:"offset_seconds"`
+	LastResult    *Result   `json:"last_result,omitempty"`
+}
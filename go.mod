@@ -3,6 +3,7 @@ module ibenc
 go 1.25.7
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/golang/snappy v0.0.4
 	github.com/prometheus/client_model v0.6.1
 	github.com/prometheus/prometheus v0.48.0
@@ -11,6 +12,7 @@ require (
 
 require (
 	github.com/gogo/protobuf v1.3.2 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
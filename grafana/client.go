@@ -0,0 +1,78 @@
+package grafana
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client uploads dashboards and rules through the Grafana HTTP API
+type Client struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for a Grafana instance authenticated with a service account token
+func NewClient(grafanaURL, token string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(grafanaURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// UploadDashboard creates or replaces a dashboard in a folder, the general folder when folder is empty
+func (c *Client) UploadDashboard(d *Dashboard, folder string) error {
+	body := map[string]any{
+		"dashboard": d,
+		"folderUid": folder,
+		"overwrite": true,
+		"message":   "Uploaded by ibenc",
+	}
+	return c.post("/api/dashboards/db", body)
+}
+
+// UploadRules creates or replaces the rule groups in a namespace of the ruler behind a Prometheus datasource
+func (c *Client) UploadRules(rules *RuleFile, datasource, namespace string) error {
+	path := fmt.Sprintf("/api/ruler/%s/api/v1/rules/%s", url.PathEscape(datasource), url.PathEscape(namespace))
+	for _, group := range rules.Groups {
+		if err := c.post(path, group); err != nil {
+			return fmt.Errorf("failed to upload rule group %s: %w", group.Name, err)
+		}
+	}
+	return nil
+}
+
+// post sends a JSON request and checks the response status
+func (c *Client) post(path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Grafana: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("grafana returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package grafana
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// request is what the test server received
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// newTestServer records requests and answers them with a status
+func newTestServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	t.Helper()
+
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{
+			method: r.Method,
+			path:   r.URL.EscapedPath(),
+			header: r.Header.Clone(),
+			body:   body,
+		})
+		w.WriteHeader(status)
+		io.WriteString(w, `{"message":"test"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestUploadDashboard(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK)
+
+	client := NewClient(server.URL+"/", "secret")
+	dashboard := &Dashboard{UID: "ibenc", Title: "ibenc"}
+	if err := client.UploadDashboard(dashboard, "folder-uid"); err != nil {
+		t.Fatalf("UploadDashboard() error = %v", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.method != http.MethodPost || req.path != "/api/dashboards/db" {
+		t.Errorf("got %s %s, want POST /api/dashboards/db", req.method, req.path)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var body struct {
+		Dashboard Dashboard `json:"dashboard"`
+		FolderUID string    `json:"folderUid"`
+		Overwrite bool      `json:"overwrite"`
+	}
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("failed to decode body %s: %v", req.body, err)
+	}
+	if body.Dashboard.UID != "ibenc" || body.Dashboard.Title != "ibenc" {
+		t.Errorf("dashboard = %+v, want uid and title ibenc", body.Dashboard)
+	}
+	if body.FolderUID != "folder-uid" {
+		t.Errorf("folderUid = %q, want folder-uid", body.FolderUID)
+	}
+	if !body.Overwrite {
+		t.Error("overwrite = false, want true")
+	}
+}
+
+func TestUploadRules(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusAccepted)
+
+	rules := &RuleFile{Groups: []RuleGroup{
+		{Name: "recording", Rules: []Rule{{Record: "ibenc:download_speed_mbps:avg1h", Expr: "avg_over_time(ibenc_download_speed_mbps[1h])"}}},
+		{Name: "alerting", Rules: []Rule{{Alert: "IbencStale", Expr: "absent(ibenc_download_speed_mbps)", For: "1h"}}},
+	}}
+
+	client := NewClient(server.URL, "secret")
+	if err := client.UploadRules(rules, "grafanacloud-prom", "ibenc home/office"); err != nil {
+		t.Fatalf("UploadRules() error = %v", err)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("got %d requests, want one per group", len(*requests))
+	}
+	for i, req := range *requests {
+		wantPath := "/api/ruler/grafanacloud-prom/api/v1/rules/ibenc%20home%2Foffice"
+		if req.method != http.MethodPost || req.path != wantPath {
+			t.Errorf("request %d: got %s %s, want POST %s", i, req.method, req.path, wantPath)
+		}
+
+		var group RuleGroup
+		if err := json.Unmarshal(req.body, &group); err != nil {
+			t.Fatalf("request %d: failed to decode body %s: %v", i, req.body, err)
+		}
+		want := rules.Groups[i]
+		if group.Name != want.Name || len(group.Rules) != 1 || group.Rules[0].Expr != want.Rules[0].Expr {
+			t.Errorf("request %d: group = %+v, want %+v", i, group, want)
+		}
+	}
+}
+
+func TestUploadRulesError(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusForbidden)
+
+	rules := &RuleFile{Groups: []RuleGroup{{Name: "first"}, {Name: "second"}}}
+	err := NewClient(server.URL, "secret").UploadRules(rules, "prom", "ibenc")
+	if err == nil {
+		t.Fatal("UploadRules() error = nil, want the response status")
+	}
+	if !strings.Contains(err.Error(), "first") || !strings.Contains(err.Error(), "403") {
+		t.Errorf("error = %q, want the group name and status", err)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("got %d requests, want the upload to stop at the first error", len(*requests))
+	}
+}
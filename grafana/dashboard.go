@@ -0,0 +1,265 @@
+package grafana
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Dashboard is the JSON model of a Grafana dashboard
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+// TimeRange is the default time range of a dashboard
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Templating holds the dashboard variables
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard variable
+type Variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label,omitempty"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	IncludeAll bool        `json:"includeAll,omitempty"`
+	Multi      bool        `json:"multi,omitempty"`
+	AllValue   string      `json:"allValue,omitempty"`
+}
+
+// Datasource references a Prometheus datasource
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// Panel is a dashboard panel
+type Panel struct {
+	ID          int         `json:"id"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	GridPos     GridPos     `json:"gridPos"`
+	Datasource  *Datasource `json:"datasource,omitempty"`
+	Targets     []Query     `json:"targets,omitempty"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+	Collapsed   bool        `json:"collapsed,omitempty"`
+}
+
+// GridPos places a panel on the 24 column grid
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Query is a PromQL query of a panel
+type Query struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+// FieldConfig holds the unit and thresholds of a panel
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+// FieldDefaults applies to every field of a panel
+type FieldDefaults struct {
+	Unit       string         `json:"unit,omitempty"`
+	Min        *float64       `json:"min,omitempty"`
+	Max        *float64       `json:"max,omitempty"`
+	Thresholds *Thresholds    `json:"thresholds,omitempty"`
+	Custom     map[string]any `json:"custom,omitempty"`
+}
+
+// Thresholds colour values by the highest step they reach
+type Thresholds struct {
+	Mode  string `json:"mode"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is a threshold step, the first one has no value
+type Step struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"`
+}
+
+// dashboardBuilder lays out panels row by row
+type dashboardBuilder struct {
+	opts       Options
+	datasource *Datasource
+	panels     []Panel
+	x, y, h    int
+}
+
+// NewDashboard builds the ibenc dashboard for the options
+func NewDashboard(opts Options) *Dashboard {
+	// Sites sharing a Grafana get their own dashboard, keyed by the series they show
+	h := fnv.New32a()
+	h.Write([]byte(opts.selector()))
+
+	d := &Dashboard{
+		UID:           fmt.Sprintf("ibenc-%08x", h.Sum32()),
+		Title:         opts.Title,
+		Tags:          []string{"ibenc", "network"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "5m",
+		Time:          TimeRange{From: "now-7d", To: "now"},
+	}
+
+	datasource := &Datasource{Type: "prometheus", UID: opts.Datasource}
+	if opts.Datasource == "" {
+		datasource.UID = "${datasource}"
+		d.Templating.List = append(d.Templating.List, Variable{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"})
+	}
+	if opts.Targets {
+		d.Templating.List = append(d.Templating.List, Variable{
+			Name:       "target",
+			Label:      "Target",
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(ibenc_run_success%s, target)", opts.selector()),
+			Datasource: datasource,
+			Refresh:    2,
+			IncludeAll: true,
+			Multi:      true,
+			AllValue:   ".*",
+		})
+	}
+
+	b := &dashboardBuilder{opts: opts, datasource: datasource}
+
+	// Latest values at a glance
+	b.stat("Download", "Mbits", b.query("ibenc_download_speed_mbps"), b.planSteps(opts.PlanDownloadMbps))
+	b.stat("Upload", "Mbits", b.query("ibenc_upload_speed_mbps"), b.planSteps(opts.PlanUploadMbps))
+	b.stat("Latency", "ms", b.query("ibenc_latency_ms"), nil)
+	b.stat("Success rate (24h)", "percentunit", opts.query("ibenc_run_success", "avg_over_time", "24h", b.matchers()...), &Thresholds{
+		Mode:  "absolute",
+		Steps: []Step{{Color: "red"}, {Color: "orange", Value: ptr(0.9)}, {Color: "green", Value: ptr(0.99)}},
+	})
+	b.newRow()
+
+	b.timeseries("Download speed", "Mbits", opts.PlanDownloadMbps, Query{Expr: b.query("ibenc_download_speed_mbps"), LegendFormat: opts.legend()})
+	b.timeseries("Upload speed", "Mbits", opts.PlanUploadMbps, Query{Expr: b.query("ibenc_upload_speed_mbps"), LegendFormat: opts.legend()})
+	b.newRow()
+	b.timeseries("Latency and jitter", "ms", 0,
+		Query{Expr: b.query("ibenc_latency_ms"), LegendFormat: "latency " + opts.legend()},
+		Query{Expr: b.query("ibenc_jitter_ms"), LegendFormat: "jitter " + opts.legend()},
+	)
+	b.timeseries("Packet loss", "percent", 0, Query{Expr: b.query("ibenc_packet_loss_percent"), LegendFormat: opts.legend()})
+	b.newRow()
+	b.timeseries("Run success", "none", 0, Query{Expr: b.query("ibenc_run_success"), LegendFormat: opts.legend()})
+	b.timeseries("Attempts per run", "none", 0, Query{Expr: b.query("ibenc_test_attempts"), LegendFormat: "{{direction}} " + opts.legend()})
+	b.newRow()
+
+	if opts.CrossTraffic {
+		b.timeseries("Cross traffic", "Mbits", 0, Query{Expr: b.query("ibenc_cross_traffic_mbps"), LegendFormat: "{{direction}} " + opts.legend()})
+		if opts.EstimateCapacity {
+			b.timeseries("Estimated capacity", "Mbits", 0, Query{Expr: b.query("ibenc_estimated_capacity_mbps"), LegendFormat: "{{direction}} " + opts.legend()})
+		}
+		b.newRow()
+	}
+	if opts.Bottleneck {
+		b.timeseries("Client CPU", "percent", 0,
+			Query{Expr: b.query("ibenc_client_cpu_percent"), LegendFormat: "client " + opts.legend()},
+			Query{Expr: b.query("ibenc_server_cpu_percent"), LegendFormat: "server " + opts.legend()},
+		)
+		b.timeseries("Client limited", "none", 0, Query{Expr: b.query("ibenc_client_limited"), LegendFormat: opts.legend()})
+		b.newRow()
+	}
+	if opts.Budget {
+		b.timeseries("Data used", "decbytes", 0, Query{Expr: b.query("ibenc_data_used_bytes"), LegendFormat: opts.legend()})
+		b.timeseries("Data budget remaining", "decbytes", 0, Query{Expr: b.query("ibenc_data_budget_remaining_bytes"), LegendFormat: opts.legend()})
+		b.newRow()
+	}
+
+	d.Panels = b.panels
+	return d
+}
+
+// matchers returns the extra label matchers of every panel query, the target variable when there is one
+func (b *dashboardBuilder) matchers() []string {
+	if b.opts.Targets {
+		return []string{`target=~"$target"`}
+	}
+	return nil
+}
+
+// query returns the panel query of a metric
+func (b *dashboardBuilder) query(name string) string {
+	return b.opts.query(name, "", "", b.matchers()...)
+}
+
+// add places a panel right of the previous one
+func (b *dashboardBuilder) add(p Panel, w, h int) {
+	p.ID = len(b.panels) + 1
+	p.GridPos = GridPos{H: h, W: w, X: b.x, Y: b.y}
+	p.Datasource = b.datasource
+	for i := range p.Targets {
+		p.Targets[i].RefID = string(rune('A' + i))
+	}
+	b.panels = append(b.panels, p)
+
+	b.x += w
+	b.h = max(b.h, h)
+}
+
+// newRow starts placing panels below the current ones
+func (b *dashboardBuilder) newRow() {
+	b.x, b.y, b.h = 0, b.y+b.h, 0
+}
+
+// stat adds a panel with the latest value of a query
+func (b *dashboardBuilder) stat(title, unit, expr string, thresholds *Thresholds) {
+	b.add(Panel{
+		Type:        "stat",
+		Title:       title,
+		Targets:     []Query{{Expr: expr, LegendFormat: b.opts.legend()}},
+		FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: unit, Thresholds: thresholds}},
+	}, 6, 4)
+}
+
+// timeseries adds a graph, with the plan and its threshold drawn as lines when plan is set
+func (b *dashboardBuilder) timeseries(title, unit string, plan float64, queries ...Query) {
+	defaults := FieldDefaults{Unit: unit, Min: ptr(0.0)}
+	if plan > 0 {
+		defaults.Thresholds = b.planSteps(plan)
+		defaults.Custom = map[string]any{"thresholdsStyle": map[string]string{"mode": "line+area"}}
+	}
+
+	b.add(Panel{Type: "timeseries", Title: title, Targets: queries, FieldConfig: FieldConfig{Defaults: defaults}}, 12, 8)
+}
+
+// planSteps colours speeds below the plan threshold red, nil without a plan
+func (b *dashboardBuilder) planSteps(plan float64) *Thresholds {
+	if plan <= 0 {
+		return nil
+	}
+	return &Thresholds{
+		Mode:  "absolute",
+		Steps: []Step{{Color: "red"}, {Color: "green", Value: ptr(b.opts.planThreshold(plan))}},
+	}
+}
+
+// ptr returns a pointer to a value
+func ptr[T any](v T) *T {
+	return &v
+}
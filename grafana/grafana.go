@@ -0,0 +1,107 @@
+package grafana
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ibenc/metrics"
+)
+
+// Options describes the ibenc deployment dashboards and rules are generated for
+type Options struct {
+	Title string
+
+	// Label values every query is restricted to, e.g. location and isp_name
+	Selector map[string]string
+	// Multiple targets get a target variable and one series per target
+	Targets bool
+
+	// Contracted speeds, 0 leaves out panels thresholds and alerts based on them
+	PlanDownloadMbps float64
+	PlanUploadMbps   float64
+	// Share of the plan below which speeds are flagged
+	PlanPercent float64
+
+	// Datasource UID, empty adds a datasource variable
+	Datasource string
+
+	// Time after which missing results are alerted, usually a few test intervals
+	StaleAfter time.Duration
+
+	// Optional features with their own panels
+	CrossTraffic     bool
+	EstimateCapacity bool
+	Bottleneck       bool
+	Budget           bool
+
+	// Query the v2 metric names, converted back to v1 units so panels and thresholds stay the same
+	SchemaV2 bool
+}
+
+// selector returns the label matchers of the options plus extra ones as a PromQL selector
+func (o Options) selector(extra ...string) string {
+	names := make([]string, 0, len(o.Selector))
+	for name := range o.Selector {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	matchers := make([]string, 0, len(names)+len(extra))
+	for _, name := range names {
+		matchers = append(matchers, name+"="+strconv.Quote(o.Selector[name]))
+	}
+	matchers = append(matchers, extra...)
+
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// query returns a v1 metric restricted to the selector plus extra matchers, wrapped in the range
+// function fn over window when fn is set
+// With SchemaV2 the v2 counterpart of the metric is queried and divided back to the v1 unit
+func (o Options) query(name, fn, window string, extra ...string) string {
+	factor := 1.0
+	if v2, ok := metrics.V2Metrics[name]; ok && o.SchemaV2 {
+		name, factor = v2.Name, v2.Factor
+		if v2.LabelName != "" {
+			extra = append(slices.Clip(extra), v2.LabelName+"="+strconv.Quote(v2.LabelValue))
+		}
+	}
+
+	expr := name + o.selector(extra...)
+	if fn != "" {
+		expr = fmt.Sprintf("%s(%s[%s])", fn, expr, window)
+	}
+	if factor != 1 {
+		expr = fmt.Sprintf("%s / %g", expr, factor)
+	}
+	return expr
+}
+
+// legend is the series name shown for ibenc series
+func (o Options) legend() string {
+	if o.Targets {
+		return "{{target}} {{ip_version}}"
+	}
+	return "{{location}} {{ip_version}}"
+}
+
+// planThreshold returns the speed below which a plan is not met, 0 without a plan
+func (o Options) planThreshold(plan float64) float64 {
+	return plan * o.PlanPercent / 100
+}
+
+// promDuration formats a duration the way PromQL and rule files accept it
+func promDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
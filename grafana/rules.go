@@ -0,0 +1,110 @@
+package grafana
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleFile is a Prometheus rule file
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups" json:"groups"`
+}
+
+// RuleGroup is a group of rules evaluated together
+type RuleGroup struct {
+	Name     string `yaml:"name" json:"name"`
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Rules    []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule is a recording or alerting rule
+type Rule struct {
+	Record      string            `yaml:"record,omitempty" json:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty" json:"alert,omitempty"`
+	Expr        string            `yaml:"expr" json:"expr"`
+	For         string            `yaml:"for,omitempty" json:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+}
+
+// NewRules builds recording and alerting rules for the options
+func NewRules(opts Options) *RuleFile {
+	sel := opts.selector()
+	warning := map[string]string{"severity": "warning"}
+
+	recording := RuleGroup{Name: "ibenc.recording", Rules: []Rule{
+		{Record: "ibenc:download_speed_mbps:avg1h", Expr: opts.query("ibenc_download_speed_mbps", "avg_over_time", "1h")},
+		{Record: "ibenc:upload_speed_mbps:avg1h", Expr: opts.query("ibenc_upload_speed_mbps", "avg_over_time", "1h")},
+		{Record: "ibenc:latency_ms:avg1h", Expr: opts.query("ibenc_latency_ms", "avg_over_time", "1h")},
+		{Record: "ibenc:run_success:ratio24h", Expr: opts.query("ibenc_run_success", "avg_over_time", "24h")},
+	}}
+	if opts.PlanDownloadMbps > 0 {
+		recording.Rules = append(recording.Rules, Rule{
+			Record: "ibenc:download_plan_ratio:avg1h",
+			Expr:   fmt.Sprintf("ibenc:download_speed_mbps:avg1h%s / %g", sel, opts.PlanDownloadMbps),
+		})
+	}
+	if opts.PlanUploadMbps > 0 {
+		recording.Rules = append(recording.Rules, Rule{
+			Record: "ibenc:upload_plan_ratio:avg1h",
+			Expr:   fmt.Sprintf("ibenc:upload_speed_mbps:avg1h%s / %g", sel, opts.PlanUploadMbps),
+		})
+	}
+
+	stale := promDuration(opts.StaleAfter)
+	alerts := RuleGroup{Name: "ibenc.alerts", Rules: []Rule{
+		{
+			Alert:  "IbencNoResults",
+			Expr:   opts.query("ibenc_run_success", "absent_over_time", stale),
+			Labels: warning,
+			Annotations: map[string]string{
+				"summary":     "ibenc has not reported results",
+				"description": fmt.Sprintf("No ibenc results for %s, check that the timer or daemon is running.", stale),
+			},
+		},
+		{
+			Alert:  "IbencTestsFailing",
+			Expr:   opts.query("ibenc_run_success", "max_over_time", stale) + " == 0",
+			Labels: warning,
+			Annotations: map[string]string{
+				"summary":     "ibenc tests are failing",
+				"description": fmt.Sprintf("Every test of {{ $labels.location }} {{ $labels.target }} failed for %s.", stale),
+			},
+		},
+		{
+			Alert:  "IbencPacketLoss",
+			Expr:   opts.query("ibenc_packet_loss_percent", "avg_over_time", "1h") + " > 1",
+			For:    "1h",
+			Labels: warning,
+			Annotations: map[string]string{
+				"summary":     "Packet loss above 1%",
+				"description": "Packet loss of {{ $labels.location }} {{ $labels.target }} averaged {{ $value | printf \"%.1f\" }}% over the last hour.",
+			},
+		},
+	}}
+	if opts.PlanDownloadMbps > 0 {
+		alerts.Rules = append(alerts.Rules, planAlert("Download", opts, opts.PlanDownloadMbps, sel))
+	}
+	if opts.PlanUploadMbps > 0 {
+		alerts.Rules = append(alerts.Rules, planAlert("Upload", opts, opts.PlanUploadMbps, sel))
+	}
+
+	return &RuleFile{Groups: []RuleGroup{recording, alerts}}
+}
+
+// planAlert fires when the hourly average of a direction stays below the share of its plan
+func planAlert(direction string, opts Options, plan float64, sel string) Rule {
+	return Rule{
+		Alert: "Ibenc" + direction + "BelowPlan",
+		Expr:  fmt.Sprintf("ibenc:%s_speed_mbps:avg1h%s < %g", strings.ToLower(direction), sel, opts.planThreshold(plan)),
+		For:   "2h",
+		Labels: map[string]string{
+			"severity": "warning",
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("%s below %g%% of the %g Mbps plan", direction, opts.PlanPercent, plan),
+			"description": fmt.Sprintf("%s speed of {{ $labels.location }} {{ $labels.target }} averaged {{ $value | printf \"%%.1f\" }} Mbps over the last hour, the plan is %g Mbps.",
+				direction, plan),
+		},
+	}
+}
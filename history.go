@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"ibenc/config"
+	"ibenc/history"
+	"ibenc/iperf3"
+)
+
+const (
+	// historyFile keeps the results shown by the web UI in the state directory
+	historyFile = "history.jsonl"
+
+	// historyRetention is how long results are kept
+	historyRetention = 90 * 24 * time.Hour
+
+	// rawDir keeps the raw iperf3 output of each run in the state directory, named by run ID
+	rawDir = "runs"
+
+	// rawRetention is how long raw output is kept, it is much larger than a history record
+	rawRetention = 30 * 24 * time.Hour
+)
+
+// historyStore returns the result history of a configuration
+func historyStore(cfg *config.Config) *history.Store {
+	return history.NewStore(cfg.StatePath(historyFile))
+}
+
+// rawStore returns the raw iperf3 output store of a configuration
+func rawStore(cfg *config.Config) *history.RawStore {
+	return history.NewRawStore(cfg.StatePath(rawDir))
+}
+
+// saveRawResult keeps the iperf3 output of a run under its ID and drops output past rawRetention
+// Failures are logged, the run's results are sent regardless
+func saveRawResult(cfg *config.Config, runID string, target config.TargetConfig, start time.Time, result *iperf3.TestResult, testErr error) {
+	raw := history.RawResult{
+		RunID:     runID,
+		Time:      start,
+		Target:    target.Name,
+		IPVersion: target.IPVersion(),
+		Download:  result.DownloadRaw,
+		Upload:    result.UploadRaw,
+	}
+	if testErr != nil {
+		raw.Error = testErr.Error()
+	}
+
+	store := rawStore(cfg)
+	if err := store.Save(raw); err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	if err := store.Prune(time.Now().Add(-rawRetention)); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// recordHistory appends the outcome of each test to the history, pruning old results once a day
+// Runs skipped by the data budget measured nothing and are left out
+func (d *daemon) recordHistory(cfg *config.Config, runs []targetRun) {
+	records := make([]history.Record, 0, len(runs))
+	for _, run := range runs {
+		if run.result == nil && run.err == nil {
+			continue
+		}
+		record := history.Record{
+			Time:      run.time,
+			RunID:     run.id,
+			Target:    run.target.Name,
+			IPVersion: run.target.IPVersion(),
+			Success:   run.err == nil,
+		}
+		if run.err != nil {
+			record.Error = run.err.Error()
+		}
+		if run.result != nil {
+			record.DownloadMbps = run.result.DownloadMbps
+			record.UploadMbps = run.result.UploadMbps
+			record.LatencyMs = run.result.LatencyMs
+			record.JitterMs = run.result.JitterMs
+			record.PacketLossPercent = run.result.PacketLossPercent
+		}
+		records = append(records, record)
+	}
+
+	store := historyStore(cfg)
+	if err := store.Append(records...); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	if time.Since(d.lastPrune) > 24*time.Hour {
+		d.lastPrune = time.Now()
+		if err := store.Prune(time.Now().Add(-historyRetention)); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
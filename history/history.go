@@ -0,0 +1,137 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Record is the outcome of one test of a target over one address family
+type Record struct {
+	Time              time.Time `json:"time"`
+	RunID             string    `json:"run_id,omitempty"`
+	Target            string    `json:"target,omitempty"`
+	IPVersion         string    `json:"ip_version,omitempty"`
+	Success           bool      `json:"success"`
+	Error             string    `json:"error,omitempty"`
+	DownloadMbps      float64   `json:"download_mbps"`
+	UploadMbps        float64   `json:"upload_mbps"`
+	LatencyMs         float64   `json:"latency_ms"`
+	JitterMs          float64   `json:"jitter_ms"`
+	PacketLossPercent float64   `json:"packet_loss_percent"`
+}
+
+// Store keeps test results as JSON lines, oldest first
+// Only one process may write to a store, readers skip a partially written last line
+type Store struct {
+	path string
+}
+
+// NewStore returns the store kept in a file
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Append adds records to the end of the store
+func (s *Store) Append(records ...Record) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+	}
+
+	return f.Close()
+}
+
+// Query returns the records since a time, of one target or of every target when target is empty
+func (s *Store) Query(since time.Time, target string) ([]Record, error) {
+	var records []Record
+	err := s.scan(func(record Record) {
+		if !record.Time.Before(since) && (target == "" || record.Target == target) {
+			records = append(records, record)
+		}
+	})
+	return records, err
+}
+
+// Prune removes the records older than a time
+func (s *Store) Prune(before time.Time) error {
+	var keep []Record
+	pruned := false
+	err := s.scan(func(record Record) {
+		if record.Time.Before(before) {
+			pruned = true
+			return
+		}
+		keep = append(keep, record)
+	})
+	if err != nil || !pruned {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't lose the history
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, record := range keep {
+		enc.Encode(record)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace history: %w", err)
+	}
+
+	return nil
+}
+
+// scan calls fn for every record in the store, a missing store is empty
+func (s *Store) scan(fn func(Record)) error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		// Lines cut short by a crash are skipped
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		fn(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return nil
+}
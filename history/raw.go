@@ -0,0 +1,115 @@
+package history
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by RawStore.Load for unknown run IDs
+var ErrNotFound = errors.New("run not found")
+
+// RawResult is the raw iperf3 output of a test, kept under its run ID
+type RawResult struct {
+	RunID     string          `json:"run_id"`
+	Time      time.Time       `json:"time"`
+	Target    string          `json:"target,omitempty"`
+	IPVersion string          `json:"ip_version,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Download  json.RawMessage `json:"download,omitempty"`
+	Upload    json.RawMessage `json:"upload,omitempty"`
+}
+
+// RawStore keeps raw results as one JSON file per run in a directory
+type RawStore struct {
+	dir string
+}
+
+// NewRawStore returns the store kept in a directory
+func NewRawStore(dir string) *RawStore {
+	return &RawStore{dir: dir}
+}
+
+// NewRunID returns a random run ID, formatted like a trace ID so tracing tools accept it
+func NewRunID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Path returns the file a run is kept in
+func (s *RawStore) Path(runID string) string {
+	return filepath.Join(s.dir, runID+".json")
+}
+
+// Save writes the raw result of a run
+func (s *RawStore) Save(result RawResult) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create raw results directory: %w", err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode raw result: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial result
+	path := s.Path(result.RunID)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write raw result: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write raw result: %w", err)
+	}
+
+	return nil
+}
+
+// Load returns the raw result of a run as stored
+func (s *RawStore) Load(runID string) ([]byte, error) {
+	// Run IDs are hex, anything else could point outside the directory
+	if _, err := hex.DecodeString(runID); err != nil || runID == "" {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.Path(runID))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw result: %w", err)
+	}
+	return data, nil
+}
+
+// Prune removes the raw results written before a time
+func (s *RawStore) Prune(before time.Time) error {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read raw results directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove raw result: %w", err)
+		}
+	}
+
+	return nil
+}
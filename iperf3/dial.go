@@ -0,0 +1,33 @@
+package iperf3
+
+import (
+	"net"
+	"time"
+)
+
+// Network returns the dial network matching the address family, e.g. "tcp6"
+func (o Options) Network() string {
+	switch o.AddressFamily {
+	case "ipv4":
+		return "tcp4"
+	case "ipv6":
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// Dialer returns a dialer for native probes that leaves through the same
+// source address and interface as iperf3 would with these options
+func (o Options) Dialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	if o.BindAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(o.BindAddress)}
+	}
+	if o.Interface != "" {
+		dialer.Control = bindToDevice(o.Interface)
+	}
+
+	return dialer
+}
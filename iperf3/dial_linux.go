@@ -0,0 +1,19 @@
+package iperf3
+
+import (
+	"syscall"
+)
+
+// bindToDevice returns a socket control function setting SO_BINDTODEVICE
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package iperf3
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice fails since SO_BINDTODEVICE is only available on Linux
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is only supported on Linux", iface)
+	}
+}
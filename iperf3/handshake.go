@@ -0,0 +1,77 @@
+package iperf3
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Control channel states sent by the server, see iperf_api.h
+const (
+	stateParamExchange = 9
+	stateAccessDenied  = -1
+	stateServerError   = -2
+)
+
+// cookieChars are the characters iperf3 uses for the session cookie
+const cookieChars = "abcdefghijklmnopqrstuvwxyz234567"
+
+// cookieSize is the length of the session cookie including the trailing NUL
+const cookieSize = 37
+
+// ServerStatus is what the iperf3 control handshake revealed about a server
+type ServerStatus struct {
+	ConnectRTT time.Duration
+	Busy       bool // another client is running a test
+}
+
+// Handshake opens an iperf3 control connection and reads the server's first state
+// A server that is running a test for someone else denies access right away
+func Handshake(opts Options, timeout time.Duration) (ServerStatus, error) {
+	address := net.JoinHostPort(opts.Server, strconv.Itoa(opts.Port))
+
+	start := time.Now()
+	conn, err := opts.Dialer(timeout).Dial(opts.Network(), address)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	status := ServerStatus{ConnectRTT: time.Since(start)}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return status, err
+	}
+	if _, err := conn.Write(newCookie()); err != nil {
+		return status, fmt.Errorf("failed to send cookie to %s: %w", address, err)
+	}
+
+	state := make([]byte, 1)
+	if _, err := conn.Read(state); err != nil {
+		return status, fmt.Errorf("no iperf3 handshake from %s: %w", address, err)
+	}
+
+	switch int8(state[0]) {
+	case stateParamExchange:
+		return status, nil
+	case stateAccessDenied:
+		status.Busy = true
+		return status, nil
+	case stateServerError:
+		return status, fmt.Errorf("iperf3 server %s reported an error", address)
+	default:
+		return status, fmt.Errorf("unexpected iperf3 state %d from %s", int8(state[0]), address)
+	}
+}
+
+// newCookie returns a random session cookie
+func newCookie() []byte {
+	cookie := make([]byte, cookieSize)
+	rand.Read(cookie[:cookieSize-1])
+	for i := 0; i < cookieSize-1; i++ {
+		cookie[i] = cookieChars[int(cookie[i])%len(cookieChars)]
+	}
+	cookie[cookieSize-1] = 0
+	return cookie
+}
@@ -1,11 +1,19 @@
 package iperf3
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os/exec"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"ibenc/netif"
 )
 
 // TestResult contains the parsed iperf3 results
@@ -15,6 +23,34 @@ type TestResult struct {
 	JitterMs      float64
 	LatencyMs     float64
 	PacketLossPercent float64
+
+	// Number of iperf3 runs needed per direction, set by RunBothTests
+	DownloadAttempts int
+	UploadAttempts   int
+
+	// Payload bytes transferred by the test per direction
+	DownloadBytes int64
+	UploadBytes   int64
+
+	// Traffic on the monitored interface that was not part of the test,
+	// set when Options.MonitorInterface is and the interface could be read
+	DownloadCrossTrafficMbps float64
+	UploadCrossTrafficMbps   float64
+	CrossTrafficMeasured     bool
+
+	// CPU use of the iperf3 processes in percent, the higher of both directions
+	ClientCPUPercent float64
+	ServerCPUPercent float64
+
+	// Per-interval samples of the test, omitted intervals left out
+	// RTT is only reported by the sending side, so mostly by upload tests over TCP
+	DownloadSamplesMbps []float64
+	UploadSamplesMbps   []float64
+	RTTSamplesMs        []float64
+
+	// Raw iperf3 JSON reports of the successful download and upload tests
+	DownloadRaw json.RawMessage
+	UploadRaw   json.RawMessage
 }
 
 // Iperf3Output is the structure of iperf3 JSON output
@@ -74,6 +110,10 @@ type Iperf3Output struct {
 			Bytes int64 `json:"bytes"`
 			BitsPerSecond float64 `json:"bits_per_second"`
 			Retransmits int `json:"retransmits"`
+			JitterMs float64 `json:"jitter_ms"`
+			LostPackets int `json:"lost_packets"`
+			Packets int `json:"packets"`
+			LostPercent float64 `json:"lost_percent"`
 		} `json:"sum"`
 		SumSent struct {
 			Start float64 `json:"start"`
@@ -92,34 +132,137 @@ type Iperf3Output struct {
 			BitsPerSecond float64 `json:"bits_per_second"`
 			Sender bool `json:"sender"`
 		} `json:"sum_received"`
+		CPUUtilizationPercent struct {
+			HostTotal float64 `json:"host_total"`
+			HostUser float64 `json:"host_user"`
+			HostSystem float64 `json:"host_system"`
+			RemoteTotal float64 `json:"remote_total"`
+			RemoteUser float64 `json:"remote_user"`
+			RemoteSystem float64 `json:"remote_system"`
+		} `json:"cpu_utilization_percent"`
 	} `json:"end"`
 }
 
-// RunTest executes iperf3 test against the server
-func RunTest(server string, port int, duration int, reverse bool) (*TestResult, error) {
+// Options holds the iperf3 client settings for a test
+type Options struct {
+	Server   string
+	Port     int
+	Duration int    // seconds
+	Parallel int    // parallel streams (-P), 0 uses the iperf3 default
+	UDP      bool   // UDP instead of TCP (-u)
+	Bitrate  string // target bitrate (-b), e.g. "100M", empty uses the iperf3 default
+
+	// Address family, "ipv4" (-4), "ipv6" (-6) or empty to let iperf3 pick
+	AddressFamily string
+
+	// Source selection for multi-WAN hosts
+	BindAddress string // local address to send from (-B)
+	Interface   string // network interface to send through (--bind-dev)
+
+	// Interface whose counters are sampled to measure cross traffic, empty disables it
+	MonitorInterface string
+
+	// Receives per-second progress while the test runs, needs iperf3 3.17 or later
+	OnInterval func(Interval)
+}
+
+// Interval is the progress of a running test over one reporting interval
+type Interval struct {
+	Reverse       bool    `json:"reverse"` // download test
+	Start         float64 `json:"start"`   // seconds since the test started
+	End           float64 `json:"end"`
+	Bytes         int64   `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"`
+}
+
+// headerOverhead scales iperf3 payload bytes to the bytes counted on the interface,
+// Ethernet, IP and TCP headers add about 4.5% at a 1500 byte MTU
+const headerOverhead = 1.045
+
+// args returns the iperf3 command line for the options
+func (o Options) args(reverse bool) []string {
 	args := []string{
-		"-c", server,
-		"-p", strconv.Itoa(port),
-		"-t", strconv.Itoa(duration),
+		"-c", o.Server,
+		"-p", strconv.Itoa(o.Port),
+		"-t", strconv.Itoa(o.Duration),
 		"-J", // JSON output
 	}
 
+	if o.Parallel > 1 {
+		args = append(args, "-P", strconv.Itoa(o.Parallel))
+	}
+	if o.UDP {
+		args = append(args, "-u")
+	}
+	if o.Bitrate != "" {
+		args = append(args, "-b", o.Bitrate)
+	}
+	switch o.AddressFamily {
+	case "ipv4":
+		args = append(args, "-4")
+	case "ipv6":
+		args = append(args, "-6")
+	}
+	if o.BindAddress != "" {
+		args = append(args, "-B", o.BindAddress)
+	}
+	if o.Interface != "" {
+		args = append(args, "--bind-dev", o.Interface)
+	}
 	if reverse {
 		args = append(args, "-R") // Reverse test (server sends to client - download)
 	}
 
-	cmd := exec.Command("iperf3", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("iperf3 command failed: %w, output: %s", err, string(output))
+	return args
+}
+
+// RunTest executes iperf3 test against the server
+func RunTest(server string, port int, duration int, reverse bool) (*TestResult, error) {
+	return RunTestWithOptions(Options{Server: server, Port: port, Duration: duration}, reverse)
+}
+
+// RunTestWithOptions executes iperf3 test with the given client settings
+func RunTestWithOptions(opts Options, reverse bool) (*TestResult, error) {
+	args := opts.args(reverse)
+
+	// Sample the interface counters around the test to see traffic from other hosts
+	var monitor *netif.Monitor
+	if opts.MonitorInterface != "" {
+		var err error
+		monitor, err = netif.StartMonitor(opts.MonitorInterface, time.Second)
+		if err != nil {
+			log.Printf("Warning: cross traffic not measured: %v", err)
+		}
 	}
 
-	var iperf3Out Iperf3Output
-	if err := json.Unmarshal(output, &iperf3Out); err != nil {
-		return nil, fmt.Errorf("failed to parse iperf3 JSON output: %w", err)
+	var iperf3Out *Iperf3Output
+	var raw json.RawMessage
+	var err error
+	if opts.OnInterval != nil {
+		iperf3Out, raw, err = runJSONStream(args, reverse, opts.OnInterval)
+	} else {
+		iperf3Out, raw, err = runJSON(args)
+	}
+	var usage netif.Usage
+	if monitor != nil {
+		var monitorErr error
+		usage, monitorErr = monitor.Stop()
+		if monitorErr != nil {
+			log.Printf("Warning: cross traffic not measured: %v", monitorErr)
+			monitor = nil
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	result := &TestResult{}
+	if reverse {
+		result.DownloadRaw = raw
+	} else {
+		result.UploadRaw = raw
+	}
 
 	// Extract throughput from end summary
 	if len(iperf3Out.End.Streams) > 0 {
@@ -141,8 +284,10 @@ func RunTest(server string, port int, duration int, reverse bool) (*TestResult,
 
 		if reverse {
 			result.DownloadMbps = mbps
+			result.DownloadBytes = iperf3Out.End.SumReceived.Bytes
 		} else {
 			result.UploadMbps = mbps
+			result.UploadBytes = iperf3Out.End.SumSent.Bytes
 		}
 
 		// Extract RTT (latency) and jitter from last stream
@@ -151,15 +296,169 @@ func RunTest(server string, port int, duration int, reverse bool) (*TestResult,
 		result.JitterMs = float64(lastStream.Rttvar) / 1000.0 // Rttvar is jitter
 	}
 
+	// Interval samples show how throughput and RTT varied within the test
+	for _, interval := range iperf3Out.Intervals {
+		if interval.Sum.Omitted || interval.Sum.Seconds <= 0 {
+			continue
+		}
+		mbps := interval.Sum.BitsPerSecond / 1_000_000
+		if reverse {
+			result.DownloadSamplesMbps = append(result.DownloadSamplesMbps, mbps)
+		} else {
+			result.UploadSamplesMbps = append(result.UploadSamplesMbps, mbps)
+		}
+		for _, stream := range interval.Streams {
+			if stream.Rtt > 0 {
+				result.RTTSamplesMs = append(result.RTTSamplesMs, float64(stream.Rtt)/1000.0)
+			}
+		}
+	}
+
 	// Packet loss is typically calculated from retransmits, but iperf3 doesn't directly provide it
 	// We'll set it to 0 for now - you might need to enhance this with additional metrics
 	result.PacketLossPercent = 0
 
+	// UDP tests do report loss and jitter as seen by the receiver
+	if opts.UDP {
+		result.PacketLossPercent = iperf3Out.End.Sum.LostPercent
+		result.JitterMs = iperf3Out.End.Sum.JitterMs
+	}
+
+	// A busy CPU on either end caps the throughput before the line does
+	result.ClientCPUPercent = iperf3Out.End.CPUUtilizationPercent.HostTotal
+	result.ServerCPUPercent = iperf3Out.End.CPUUtilizationPercent.RemoteTotal
+
+	// Cross traffic is what the interface carried beyond the test's own bytes
+	if monitor != nil && usage.Elapsed > 0 {
+		interfaceBytes, testBytes := usage.TxBytes, result.UploadBytes
+		if reverse {
+			interfaceBytes, testBytes = usage.RxBytes, result.DownloadBytes
+		}
+		crossBytes := math.Max(0, float64(interfaceBytes)-float64(testBytes)*headerOverhead)
+		crossMbps := crossBytes * 8 / usage.Elapsed.Seconds() / 1_000_000
+
+		if reverse {
+			result.DownloadCrossTrafficMbps = crossMbps
+		} else {
+			result.UploadCrossTrafficMbps = crossMbps
+		}
+		result.CrossTrafficMeasured = true
+	}
+
 	return result, nil
 }
 
+// runJSON runs iperf3 and parses its JSON report, returning the report as well
+func runJSON(args []string) (*Iperf3Output, json.RawMessage, error) {
+	cmd := exec.Command("iperf3", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, nil, fmt.Errorf("iperf3 command failed: %w, output: %s", err, string(output))
+	}
+
+	var iperf3Out Iperf3Output
+	if err := json.Unmarshal(output, &iperf3Out); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse iperf3 JSON output: %w", err)
+	}
+	return &iperf3Out, output, nil
+}
+
+// runJSONStream runs iperf3 with --json-stream, passing every interval to onInterval
+// as it arrives, and assembles the events into the same report runJSON returns
+func runJSONStream(args []string, reverse bool, onInterval func(Interval)) (*Iperf3Output, json.RawMessage, error) {
+	cmd := exec.Command("iperf3", append(args, "--json-stream")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start iperf3: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start iperf3: %w", err)
+	}
+
+	var iperf3Out Iperf3Output
+	var iperf3Err string
+	// The raw events in the layout of the report without --json-stream
+	var raw struct {
+		Start     json.RawMessage   `json:"start,omitempty"`
+		Intervals []json.RawMessage `json:"intervals"`
+		End       json.RawMessage   `json:"end,omitempty"`
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		// Every line is an event like {"event": "interval", "data": {...}}
+		var event struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+
+		switch event.Event {
+		case "start":
+			raw.Start = event.Data
+			err = json.Unmarshal(event.Data, &iperf3Out.Start)
+		case "interval":
+			raw.Intervals = append(raw.Intervals, event.Data)
+			n := len(iperf3Out.Intervals)
+			iperf3Out.Intervals = slices.Grow(iperf3Out.Intervals, 1)[:n+1]
+			last := &iperf3Out.Intervals[n]
+			if err = json.Unmarshal(event.Data, last); err == nil {
+				onInterval(Interval{
+					Reverse:       reverse,
+					Start:         last.Sum.Start,
+					End:           last.Sum.End,
+					Bytes:         last.Sum.Bytes,
+					BitsPerSecond: last.Sum.BitsPerSecond,
+					Retransmits:   last.Sum.Retransmits,
+				})
+			}
+		case "end":
+			raw.End = event.Data
+			err = json.Unmarshal(event.Data, &iperf3Out.End)
+		case "error":
+			json.Unmarshal(event.Data, &iperf3Err)
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, nil, fmt.Errorf("failed to parse iperf3 JSON stream: %w", err)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, nil, fmt.Errorf("iperf3 command failed: %w, output: %s%s", err, iperf3Err, stderr.String())
+	}
+
+	report, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to assemble iperf3 JSON report: %w", err)
+	}
+	return &iperf3Out, report, nil
+}
+
+// SupportsJSONStream reports whether an iperf3 version, as returned by Version, has --json-stream
+func SupportsJSONStream(version string) bool {
+	major, minor, _ := strings.Cut(version, ".")
+	minor, _, _ = strings.Cut(minor, ".")
+	ma, err1 := strconv.Atoi(major)
+	mi, err2 := strconv.Atoi(strings.TrimRight(minor, "abcdefghijklmnopqrstuvwxyz+-"))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return ma > 3 || (ma == 3 && mi >= 17)
+}
+
 // RunBothTests runs both download and upload tests, with graceful fallback and retries
 func RunBothTests(server string, port int, duration int) (*TestResult, error) {
+	return RunBothTestsWithOptions(Options{Server: server, Port: port, Duration: duration})
+}
+
+// RunBothTestsWithOptions runs both download and upload tests with the given client settings
+func RunBothTestsWithOptions(opts Options) (*TestResult, error) {
 	result := &TestResult{}
 	maxRetries := 2
 
@@ -167,11 +466,21 @@ func RunBothTests(server string, port int, duration int) (*TestResult, error) {
 	var downloadResult *TestResult
 	var err error
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		downloadResult, err = RunTest(server, port, duration, true)
+		result.DownloadAttempts++
+		downloadResult, err = RunTestWithOptions(opts, true)
 		if err == nil {
 			result.DownloadMbps = downloadResult.DownloadMbps
 			result.LatencyMs = downloadResult.LatencyMs
 			result.JitterMs = downloadResult.JitterMs
+			result.PacketLossPercent = downloadResult.PacketLossPercent
+			result.DownloadBytes = downloadResult.DownloadBytes
+			result.DownloadCrossTrafficMbps = downloadResult.DownloadCrossTrafficMbps
+			result.CrossTrafficMeasured = downloadResult.CrossTrafficMeasured
+			result.ClientCPUPercent = downloadResult.ClientCPUPercent
+			result.ServerCPUPercent = downloadResult.ServerCPUPercent
+			result.DownloadSamplesMbps = downloadResult.DownloadSamplesMbps
+			result.RTTSamplesMs = downloadResult.RTTSamplesMs
+			result.DownloadRaw = downloadResult.DownloadRaw
 			break
 		}
 		if attempt < maxRetries-1 {
@@ -185,7 +494,8 @@ func RunBothTests(server string, port int, duration int) (*TestResult, error) {
 	// Try upload test (normal) with retries
 	var uploadResult *TestResult
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		uploadResult, err = RunTest(server, port, duration, false)
+		result.UploadAttempts++
+		uploadResult, err = RunTestWithOptions(opts, false)
 		if err == nil {
 			break
 		}
@@ -196,9 +506,9 @@ func RunBothTests(server string, port int, duration int) (*TestResult, error) {
 
 	if err != nil {
 		log.Printf("Upload test failed after %d attempts", maxRetries)
-		// If both tests failed, return error
+		// If both tests failed, return error along with the attempt counts
 		if result.DownloadMbps == 0 {
-			return nil, fmt.Errorf("both download and upload tests failed")
+			return result, fmt.Errorf("both download and upload tests failed")
 		}
 		// But if download succeeded, we can still return partial results
 		return result, nil
@@ -206,6 +516,15 @@ func RunBothTests(server string, port int, duration int) (*TestResult, error) {
 
 	// Combine results
 	result.UploadMbps = uploadResult.UploadMbps
+	result.UploadBytes = uploadResult.UploadBytes
+	result.UploadCrossTrafficMbps = uploadResult.UploadCrossTrafficMbps
+	result.CrossTrafficMeasured = result.CrossTrafficMeasured || uploadResult.CrossTrafficMeasured
+	result.UploadSamplesMbps = uploadResult.UploadSamplesMbps
+	result.RTTSamplesMs = append(result.RTTSamplesMs, uploadResult.RTTSamplesMs...)
+	result.UploadRaw = uploadResult.UploadRaw
+
+	result.ClientCPUPercent = math.Max(result.ClientCPUPercent, uploadResult.ClientCPUPercent)
+	result.ServerCPUPercent = math.Max(result.ServerCPUPercent, uploadResult.ServerCPUPercent)
 
 	// Use better latency/jitter if available
 	if uploadResult.LatencyMs > 0 && (result.LatencyMs == 0 || uploadResult.LatencyMs < result.LatencyMs) {
@@ -215,5 +534,26 @@ func RunBothTests(server string, port int, duration int) (*TestResult, error) {
 		result.JitterMs = uploadResult.JitterMs
 	}
 
+	// Report the worse loss of the two directions
+	if uploadResult.PacketLossPercent > result.PacketLossPercent {
+		result.PacketLossPercent = uploadResult.PacketLossPercent
+	}
+
 	return result, nil
 }
+
+// Version returns the version of the installed iperf3 binary, e.g. "3.16"
+func Version() (string, error) {
+	output, err := exec.Command("iperf3", "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("iperf3 --version failed: %w", err)
+	}
+
+	// First line looks like "iperf 3.16 (cJSON 1.7.15)"
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unexpected iperf3 --version output: %s", string(output))
+	}
+
+	return fields[1], nil
+}
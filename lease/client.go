@@ -0,0 +1,190 @@
+package lease
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client requests leases from a lease server
+type Client struct {
+	URL    string // base URL of the lease server, e.g. http://192.0.2.10:5299
+	Token  string // shared secret of the server
+	Holder string // name of this instance shown to other holders
+
+	httpClient *http.Client
+}
+
+// NewClient creates a lease client
+func NewClient(serverURL, token, holder string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(serverURL, "/"),
+		Token:      token,
+		Holder:     holder,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Lease is a granted lease, renewed in the background until released
+type Lease struct {
+	client *Client
+	name   string
+	token  string
+	ttl    time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu   sync.Mutex
+	lost error // set once another instance may have taken over the uplink
+}
+
+// Acquire waits up to wait for the lease of an uplink and keeps renewing it every third of ttl
+func (c *Client) Acquire(name string, ttl, wait time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		info, err := c.request(name, "", ttl)
+		if err == nil {
+			l := &Lease{client: c, name: name, token: info.Token, ttl: ttl, stop: make(chan struct{})}
+			l.wg.Add(1)
+			go l.renew()
+			return l, nil
+		}
+		if !isBusy(err) || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// renew extends the lease until it is released
+// Unreachable servers are retried, a lease taken by another holder or expired in between is lost
+func (l *Lease) renew() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			info, err := l.client.request(l.name, l.token, l.ttl)
+			switch {
+			case isBusy(err):
+				l.setLost(fmt.Errorf("lease %s lost: %w", l.name, err))
+				return
+			case err != nil:
+				log.Printf("Warning: failed to renew lease %s: %v", l.name, err)
+			case info.Token != l.token:
+				// Granted anew, so it expired and someone else may have tested meanwhile
+				l.token = info.Token
+				l.setLost(fmt.Errorf("lease %s expired before it was renewed", l.name))
+			}
+		}
+	}
+}
+
+// setLost records why the lease was lost
+func (l *Lease) setLost(err error) {
+	log.Printf("Warning: %v", err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost == nil {
+		l.lost = err
+	}
+}
+
+// Lost returns why the lease was lost while held, or nil
+func (l *Lease) Lost() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Release ends the lease
+func (l *Lease) Release() error {
+	close(l.stop)
+	l.wg.Wait()
+
+	req, err := http.NewRequest(http.MethodDelete, l.client.leaseURL(l.name)+"?token="+url.QueryEscape(l.token), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := l.client.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", l.name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to release lease %s: status %d", l.name, resp.StatusCode)
+	}
+	return nil
+}
+
+// busyError is returned when another instance holds the lease
+type busyError struct {
+	holder string
+}
+
+func (e *busyError) Error() string { return fmt.Sprintf("%v: lease held by %s", ErrBusy, e.holder) }
+func (e *busyError) Unwrap() error { return ErrBusy }
+
+// isBusy reports whether an error means the lease is held by someone else
+func isBusy(err error) bool {
+	_, ok := err.(*busyError)
+	return ok
+}
+
+// request asks the server for a lease, or renews it when token is set
+func (c *Client) request(name, token string, ttl time.Duration) (*leaseInfo, error) {
+	body, err := json.Marshal(acquireRequest{Holder: c.Holder, Token: token, TTLSeconds: int(ttl.Seconds())})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lease request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.leaseURL(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach lease server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var info leaseInfo
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return nil, fmt.Errorf("failed to parse lease response: %w", err)
+		}
+		return &info, nil
+	case http.StatusConflict:
+		json.NewDecoder(resp.Body).Decode(&info)
+		return nil, &busyError{holder: info.Holder}
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("lease server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
+
+// do sends a request with the shared token
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// leaseURL returns the URL of a named lease
+func (c *Client) leaseURL(name string) string {
+	return c.URL + "/v1/leases/" + url.PathEscape(name)
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package lease
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// FileLock is an exclusive flock on a file, released when the process exits
+type FileLock struct {
+	f *os.File
+}
+
+// LockFile takes an exclusive lock on a file, waiting up to wait for another holder to release it
+func LockFile(path string, wait time.Duration) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, ErrBusy)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	// The holder's PID helps finding who keeps the lock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &FileLock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	defer l.f.Close()
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("failed to unlock %s: %w", l.f.Name(), err)
+	}
+	return nil
+}
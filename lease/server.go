@@ -0,0 +1,163 @@
+package lease
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when another holder has the lock or lease
+var ErrBusy = errors.New("another test is running")
+
+// pollInterval is how often a busy lock or lease is retried
+const pollInterval = 500 * time.Millisecond
+
+// maxTTL bounds the lease time a client may ask for
+const maxTTL = 10 * time.Minute
+
+// acquireRequest is the body of a lease request, a known token renews the lease
+type acquireRequest struct {
+	Holder     string `json:"holder"`
+	Token      string `json:"token,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+// leaseInfo describes a lease in responses
+type leaseInfo struct {
+	Name    string    `json:"name"`
+	Holder  string    `json:"holder"`
+	Token   string    `json:"token,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Server hands out one lease per uplink name to the ibenc instances sharing it
+type Server struct {
+	token string // shared secret clients send as a bearer token, empty disables auth
+
+	mu     sync.Mutex
+	leases map[string]*leaseInfo
+}
+
+// NewServer creates a lease server
+func NewServer(token string) *Server {
+	return &Server{token: token, leases: make(map[string]*leaseInfo)}
+}
+
+// Handler returns the HTTP API of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/leases", s.authorized(s.handleList))
+	mux.HandleFunc("POST /v1/leases/{name}", s.authorized(s.handleAcquire))
+	mux.HandleFunc("DELETE /v1/leases/{name}", s.authorized(s.handleRelease))
+	return mux
+}
+
+// authorized rejects requests without the shared token
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			want := "Bearer " + s.token
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleList returns the active leases
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.prune(time.Now())
+	leases := make([]leaseInfo, 0, len(s.leases))
+	for _, l := range s.leases {
+		leases = append(leases, leaseInfo{Name: l.Name, Holder: l.Holder, Expires: l.Expires})
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, leases)
+}
+
+// handleAcquire grants or renews a lease, or reports the current holder with 409
+func (s *Server) handleAcquire(w http.ResponseWriter, r *http.Request) {
+	var req acquireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl <= 0 || ttl > maxTTL {
+		http.Error(w, "ttl_seconds out of range", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("name")
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	// An expired lease is gone, renewing it hands out a new token so the holder notices
+	current := s.leases[name]
+	if current != nil && current.Token != req.Token {
+		writeJSON(w, http.StatusConflict, leaseInfo{Name: name, Holder: current.Holder, Expires: current.Expires})
+		return
+	}
+
+	if current == nil {
+		current = &leaseInfo{Name: name, Token: newToken()}
+		s.leases[name] = current
+	}
+	current.Holder = req.Holder
+	current.Expires = now.Add(ttl)
+
+	writeJSON(w, http.StatusOK, current)
+}
+
+// handleRelease ends a lease held with the given token
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	token := r.URL.Query().Get("token")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.leases[name]
+	if current == nil || current.Token != token {
+		http.Error(w, "lease not held", http.StatusNotFound)
+		return
+	}
+	delete(s.leases, name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// prune forgets expired leases, holders that crashed never release theirs
+func (s *Server) prune(now time.Time) {
+	for name, l := range s.leases {
+		if !now.Before(l.Expires) {
+			delete(s.leases, name)
+		}
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newToken returns a random lease token
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
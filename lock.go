@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"ibenc/config"
+	"ibenc/lease"
+)
+
+// leaseTTL is how long a lease outlives a crashed holder, it is renewed while the test runs
+const leaseTTL = time.Minute
+
+// runLock keeps other ibenc instances from testing the same uplink at the same time
+type runLock struct {
+	file  *lease.FileLock
+	lease *lease.Lease
+}
+
+// acquireRunLock takes the host lock of a target's uplink and, with lock.server, its LAN lease
+// An unreachable lease server is logged and ignored so measurements don't stop with it
+func acquireRunLock(cfg *config.Config, target config.TargetConfig) (*runLock, error) {
+	name := "default"
+	if target.WAN != "" {
+		name = target.WAN
+	}
+
+	file, err := lease.LockFile(cfg.StatePath("ibenc-"+name+".lock"), cfg.Lock.Wait)
+	if err != nil {
+		return nil, err
+	}
+	l := &runLock{file: file}
+
+	if cfg.Lock.Server != "" {
+		holder, _ := os.Hostname()
+		client := lease.NewClient(cfg.Lock.Server, cfg.Lock.Token, fmt.Sprintf("%s/%d", holder, os.Getpid()))
+		l.lease, err = client.Acquire(name, leaseTTL, cfg.Lock.Wait)
+		if errors.Is(err, lease.ErrBusy) {
+			l.release()
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Warning: running without LAN lease: %v", err)
+		}
+	}
+
+	return l, nil
+}
+
+// lost returns why the LAN lease was lost during the test, or nil
+func (l *runLock) lost() error {
+	if l.lease == nil {
+		return nil
+	}
+	return l.lease.Lost()
+}
+
+// release gives up the lease and the host lock
+func (l *runLock) release() {
+	if l.lease != nil {
+		if err := l.lease.Release(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if err := l.file.Unlock(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
@@ -3,72 +3,70 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 
+	"github.com/prometheus/client_model/go"
 	"ibenc/config"
-	"ibenc/iperf3"
-	"ibenc/metrics"
-	"ibenc/remote"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "daemon":
+			os.Exit(runDaemon(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "servers":
+			os.Exit(runServersCommand(os.Args[2:]))
+		case "lease-server":
+			os.Exit(runLeaseServer(os.Args[2:]))
+		case "controller":
+			os.Exit(runController(os.Args[2:]))
+		case "grafana":
+			os.Exit(runGrafana(os.Args[2:]))
+		}
+	}
+
 	// Command line flags
 	configPath := flag.String("config", "ibenc.yaml", "path to configuration file")
+	overrides := config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Load configuration
-	cfg, err := config.LoadConfigWithDefaults(*configPath)
+	cfg, err := config.LoadConfigWithOverrides(*configPath, overrides)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v\n", err)
 	}
-
-	log.Printf("Starting iperf3 benchmark against %s:%d\n", cfg.Iperf3.Server, cfg.Iperf3.Port)
-
-	// Run iperf3 tests
-	testResult, err := iperf3.RunBothTests(cfg.Iperf3.Server, cfg.Iperf3.Port, cfg.Iperf3.Duration)
-	if err != nil {
-		log.Fatalf("Test failed: %v\n", err)
+	for _, warning := range cfg.Warnings() {
+		log.Printf("Warning: %s", warning)
 	}
 
-	log.Printf("Test Results:")
-	log.Printf("  Download: %.2f Mbps\n", testResult.DownloadMbps)
-	log.Printf("  Upload: %.2f Mbps\n", testResult.UploadMbps)
-	log.Printf("  Latency: %.2f ms\n", testResult.LatencyMs)
-	log.Printf("  Jitter: %.2f ms\n", testResult.JitterMs)
-	log.Printf("  Packet Loss: %.2f %%\n", testResult.PacketLossPercent)
-
-	// Create metrics
-	metricLabels := metrics.MetricLabels{
-		Location:    cfg.Metrics.Location,
-		ISPName:     cfg.Metrics.ISPName,
-		PackageName: cfg.Metrics.PackageName,
-	}
-
-	metricsData := metrics.ExportMetrics(testResult, metricLabels)
-
-	// Check if test produced any meaningful results
-	// Only send metrics if we got at least some valid data
-	if testResult.DownloadMbps == 0 && testResult.UploadMbps == 0 {
-		log.Println("❌ Error: Test results are 0 - iperf3 connection failed")
-		log.Println("   This usually means:")
-		log.Println("   - iperf3 server is unreachable")
-		log.Println("   - Firewall is blocking port 5201")
-		log.Println("   - Network connectivity issue")
-		log.Println("")
-		log.Println("   No metrics will be sent. Fix the connection and try again.")
-		return
+	// Run iperf3 tests, one target at a time so they don't compete for the link
+	var metricsData []*io_prometheus_client.MetricFamily
+	var testErr error
+	for _, target := range cfg.EffectiveTargets() {
+		targetMetrics, _, err := runTarget(cfg, target, nil)
+		metricsData = append(metricsData, targetMetrics...)
+		if err != nil {
+			log.Printf("Test failed: %v\n", err)
+			testErr = err
+		}
 	}
 
 	// Send to Grafana Cloud
-	writer := remote.NewWriter(remote.Config{
-		PrometheusURL: cfg.Prometheus.URL,
-		Username:      cfg.Prometheus.Username,
-		Password:      cfg.Prometheus.Password,
-	})
-
-	log.Printf("Sending metrics to %s\n", cfg.Prometheus.URL)
-	if err := writer.WriteMetrics(metricsData); err != nil {
+	if err := sendMetrics(cfg, metricsData); err != nil {
 		log.Fatalf("Failed to send metrics: %v\n", err)
 	}
 
+	if testErr != nil {
+		log.Fatalf("Some tests failed, last error: %v\n", testErr)
+	}
+
 	log.Println("Metrics sent successfully!")
 }
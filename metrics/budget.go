@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_model/go"
+)
+
+// BudgetStats describes the data budget after a run
+type BudgetStats struct {
+	UsedBytes      int64
+	RemainingBytes int64
+	Skipped        bool // the run didn't fit in the budget
+	Duration       int  // test duration chosen to fit the budget, in seconds
+}
+
+// ExportBudgetMetrics converts data budget statistics to Prometheus metrics
+func ExportBudgetMetrics(stats BudgetStats, labels MetricLabels) []*io_prometheus_client.MetricFamily {
+	timestamp := time.Now().UnixMilli()
+
+	skipped := 0.0
+	if stats.Skipped {
+		skipped = 1
+	}
+
+	metrics := make([]*io_prometheus_client.MetricFamily, 0)
+
+	// Data usage metrics
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_data_used_bytes",
+		"Bytes transferred by tests in the current billing period",
+		float64(stats.UsedBytes),
+		labels,
+		timestamp,
+	))
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_data_budget_remaining_bytes",
+		"Bytes left in the data budget of the current billing period",
+		float64(stats.RemainingBytes),
+		labels,
+		timestamp,
+	))
+
+	// Adaptation metrics
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_budget_skipped",
+		"Whether the run was skipped to stay within the data budget (1) or not (0)",
+		skipped,
+		labels,
+		timestamp,
+	))
+	if !stats.Skipped {
+		metrics = append(metrics, createGaugeMetric(
+			"ibenc_test_duration_seconds",
+			"Test duration per direction chosen to stay within the data budget",
+			float64(stats.Duration),
+			labels,
+			timestamp,
+		))
+	}
+
+	return metrics
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_model/go"
+)
+
+// ClientStats describes the measuring host during a test
+type ClientStats struct {
+	CPUPercent       float64 // iperf3 client CPU use
+	ServerCPUPercent float64 // iperf3 server CPU use
+	LinkSpeedMbps    float64 // negotiated speed of a wired interface, 0 when unknown
+	Wireless         bool
+	WifiSignalDBm    float64
+	WifiBitrateMbps  float64 // 0 when unknown
+	Limited          bool    // the client, not the line, was the bottleneck
+}
+
+// ExportClientMetrics converts client statistics to Prometheus metrics
+func ExportClientMetrics(stats ClientStats, labels MetricLabels) []*io_prometheus_client.MetricFamily {
+	timestamp := time.Now().UnixMilli()
+
+	limited := 0.0
+	if stats.Limited {
+		limited = 1
+	}
+
+	metrics := make([]*io_prometheus_client.MetricFamily, 0)
+
+	// CPU metrics
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_client_cpu_percent",
+		"CPU use of the iperf3 client during the test in percent",
+		stats.CPUPercent,
+		labels,
+		timestamp,
+	))
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_server_cpu_percent",
+		"CPU use of the iperf3 server during the test in percent",
+		stats.ServerCPUPercent,
+		labels,
+		timestamp,
+	))
+
+	// Link speed metric
+	if stats.LinkSpeedMbps > 0 {
+		metrics = append(metrics, createGaugeMetric(
+			"ibenc_link_speed_mbps",
+			"Negotiated speed of the client's network interface in Mbps",
+			stats.LinkSpeedMbps,
+			labels,
+			timestamp,
+		))
+	}
+
+	// Wi-Fi metrics
+	if stats.Wireless {
+		metrics = append(metrics, createGaugeMetric(
+			"ibenc_wifi_signal_dbm",
+			"Wi-Fi signal level of the client in dBm",
+			stats.WifiSignalDBm,
+			labels,
+			timestamp,
+		))
+		if stats.WifiBitrateMbps > 0 {
+			metrics = append(metrics, createGaugeMetric(
+				"ibenc_wifi_bitrate_mbps",
+				"Wi-Fi transmit bitrate of the client in Mbps",
+				stats.WifiBitrateMbps,
+				labels,
+				timestamp,
+			))
+		}
+	}
+
+	// Bottleneck flag metric
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_client_limited",
+		"Whether the measuring host was the bottleneck of the test (1) or not (0)",
+		limited,
+		labels,
+		timestamp,
+	))
+
+	return metrics
+}
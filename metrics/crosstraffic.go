@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/iperf3"
+)
+
+// CrossTrafficOptions controls the metrics derived from cross traffic
+type CrossTrafficOptions struct {
+	EstimateCapacity bool    // export throughput plus cross traffic as the estimated line capacity
+	ThresholdMbps    float64 // flag runs with more cross traffic than this, 0 disables the flag
+}
+
+// CrossTrafficExceeded reports whether either direction saw more cross traffic than the threshold
+func CrossTrafficExceeded(result *iperf3.TestResult, thresholdMbps float64) bool {
+	return thresholdMbps > 0 &&
+		(result.DownloadCrossTrafficMbps > thresholdMbps || result.UploadCrossTrafficMbps > thresholdMbps)
+}
+
+// ExportCrossTrafficMetrics converts the cross traffic seen during a test to Prometheus metrics
+func ExportCrossTrafficMetrics(result *iperf3.TestResult, labels MetricLabels, opts CrossTrafficOptions) []*io_prometheus_client.MetricFamily {
+	timestamp := time.Now().UnixMilli()
+
+	metrics := make([]*io_prometheus_client.MetricFamily, 0)
+
+	// Cross traffic metric
+	metrics = append(metrics, createDirectionalGaugeMetric(
+		"ibenc_cross_traffic_mbps",
+		"Traffic on the WAN interface during the test that was not part of it, in Mbps",
+		result.DownloadCrossTrafficMbps,
+		result.UploadCrossTrafficMbps,
+		labels,
+		timestamp,
+	))
+
+	// Estimated capacity metric
+	if opts.EstimateCapacity {
+		metrics = append(metrics, createDirectionalGaugeMetric(
+			"ibenc_estimated_capacity_mbps",
+			"Measured speed plus cross traffic, an estimate of the line capacity in Mbps",
+			result.DownloadMbps+result.DownloadCrossTrafficMbps,
+			result.UploadMbps+result.UploadCrossTrafficMbps,
+			labels,
+			timestamp,
+		))
+	}
+
+	// Threshold flag metric
+	if opts.ThresholdMbps > 0 {
+		exceeded := 0.0
+		if CrossTrafficExceeded(result, opts.ThresholdMbps) {
+			exceeded = 1
+		}
+		metrics = append(metrics, createGaugeMetric(
+			"ibenc_cross_traffic_exceeded",
+			"Whether cross traffic during the test exceeded the configured threshold (1) or not (0)",
+			exceeded,
+			labels,
+			timestamp,
+		))
+	}
+
+	return metrics
+}
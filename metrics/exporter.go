@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"sort"
 	"time"
 
 	"github.com/prometheus/client_model/go"
@@ -12,8 +13,23 @@ type MetricLabels struct {
 	Location    string
 	ISPName     string
 	PackageName string
+
+	// Measurement target name, uplink name and IP version, left out when empty
+	Target    string
+	WAN       string
+	IPVersion string
+
+	// Free-form labels added to every series, empty values are left out
+	Custom map[string]string
+
+	// ID of the run, attached to throughput samples as an exemplar rather than a label
+	RunID string
 }
 
+// TraceIDLabel carries the run ID of a sample to the sinks, which send it as a trace_id exemplar
+// or drop it, never as a label
+const TraceIDLabel = "__ibenc_trace_id"
+
 // ExportMetrics converts test results to Prometheus metrics
 func ExportMetrics(result *iperf3.TestResult, labels MetricLabels) []*io_prometheus_client.MetricFamily {
 	timestamp := time.Now().UnixMilli()
@@ -27,6 +43,7 @@ func ExportMetrics(result *iperf3.TestResult, labels MetricLabels) []*io_prometh
 		result.DownloadMbps,
 		labels,
 		timestamp,
+		labels.exemplar()...,
 	))
 
 	// Upload speed metric
@@ -36,6 +53,7 @@ func ExportMetrics(result *iperf3.TestResult, labels MetricLabels) []*io_prometh
 		result.UploadMbps,
 		labels,
 		timestamp,
+		labels.exemplar()...,
 	))
 
 	// Jitter metric
@@ -68,28 +86,23 @@ func ExportMetrics(result *iperf3.TestResult, labels MetricLabels) []*io_prometh
 	return metrics
 }
 
+// label is an additional label pair for a single metric
+type label struct {
+	name  string
+	value string
+}
+
 // createGaugeMetric creates a Prometheus gauge metric
-func createGaugeMetric(name, help string, value float64, labels MetricLabels, timestamp int64) *io_prometheus_client.MetricFamily {
+func createGaugeMetric(name, help string, value float64, labels MetricLabels, timestamp int64, extra ...label) *io_prometheus_client.MetricFamily {
+	labelPairs := labels.labelPairs(extra...)
+
 	mf := &io_prometheus_client.MetricFamily{
 		Name: &name,
 		Help: &help,
 		Type: io_prometheus_client.MetricType_GAUGE.Enum(),
 		Metric: []*io_prometheus_client.Metric{
 			{
-				Label: []*io_prometheus_client.LabelPair{
-					{
-						Name:  stringPtr("location"),
-						Value: &labels.Location,
-					},
-					{
-						Name:  stringPtr("isp_name"),
-						Value: &labels.ISPName,
-					},
-					{
-						Name:  stringPtr("package_name"),
-						Value: &labels.PackageName,
-					},
-				},
+				Label: labelPairs,
 				Gauge: &io_prometheus_client.Gauge{
 					Value: &value,
 				},
@@ -100,6 +113,58 @@ func createGaugeMetric(name, help string, value float64, labels MetricLabels, ti
 	return mf
 }
 
+// createDirectionalGaugeMetric creates a gauge with one series per direction
+func createDirectionalGaugeMetric(name, help string, download, upload float64, labels MetricLabels, timestamp int64) *io_prometheus_client.MetricFamily {
+	mf := createGaugeMetric(name, help, download, labels, timestamp, label{"direction", "download"})
+	mf.Metric = append(mf.Metric, createGaugeMetric(name, help, upload, labels, timestamp, label{"direction", "upload"}).Metric...)
+	return mf
+}
+
+// exemplar returns the label carrying the run ID, none without one
+func (l MetricLabels) exemplar() []label {
+	if l.RunID == "" {
+		return nil
+	}
+	return []label{{TraceIDLabel, l.RunID}}
+}
+
+// labelPairs returns the common labels plus the extra ones, sorted by name
+func (l MetricLabels) labelPairs(extra ...label) []*io_prometheus_client.LabelPair {
+	all := []label{
+		{"location", l.Location},
+		{"isp_name", l.ISPName},
+		{"package_name", l.PackageName},
+	}
+	if l.Target != "" {
+		all = append(all, label{"target", l.Target})
+	}
+	if l.WAN != "" {
+		all = append(all, label{"wan", l.WAN})
+	}
+	if l.IPVersion != "" {
+		all = append(all, label{"ip_version", l.IPVersion})
+	}
+	for name, value := range l.Custom {
+		if value != "" {
+			all = append(all, label{name, value})
+		}
+	}
+	all = append(all, extra...)
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].name < all[j].name
+	})
+
+	labelPairs := make([]*io_prometheus_client.LabelPair, 0, len(all))
+	for _, lp := range all {
+		labelPairs = append(labelPairs, &io_prometheus_client.LabelPair{
+			Name:  stringPtr(lp.name),
+			Value: stringPtr(lp.value),
+		})
+	}
+	return labelPairs
+}
+
 // stringPtr returns a pointer to a string
 func stringPtr(s string) *string {
 	return &s
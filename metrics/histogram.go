@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/iperf3"
+)
+
+// HistogramOptions selects the kinds of histograms built from interval samples
+type HistogramOptions struct {
+	Classic bool // fixed buckets, _bucket, _sum and _count series
+	Native  bool // exponential buckets, needs native histograms enabled on the receiving end
+
+	Schema Schema
+}
+
+// Bucket upper bounds of the classic histograms
+var (
+	throughputBucketsMbps = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+	rttBucketsMs          = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+	// The same bounds in the base units of the v2 schema
+	throughputBucketsBitsPerSecond = []float64{1e6, 2e6, 5e6, 1e7, 2e7, 5e7, 1e8, 2e8, 5e8, 1e9, 2e9, 5e9, 1e10}
+	rttBucketsSeconds              = []float64{0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1}
+)
+
+const (
+	// nativeSchema splits each power of two into 2^3 buckets, about 9% wide
+	nativeSchema = 3
+
+	// nativeZeroThreshold is the default width of the zero bucket of Prometheus native histograms
+	nativeZeroThreshold = 2.938735877055719e-39
+)
+
+// intervalHistogram is a histogram of interval samples in one metric schema
+type intervalHistogram struct {
+	name    string
+	help    string
+	factor  float64 // converts the Mbps and ms samples to the unit of the schema
+	buckets []float64
+}
+
+// intervalHistograms returns the throughput and RTT histograms of the selected schemas
+// Buckets can't be rescaled once filled, so each schema gets its own histograms
+func intervalHistograms(schema Schema) (throughput, rtt []intervalHistogram) {
+	if schema.V1 {
+		throughput = append(throughput, intervalHistogram{"ibenc_interval_throughput_mbps", "Throughput of each test interval in Mbps", 1, throughputBucketsMbps})
+		rtt = append(rtt, intervalHistogram{"ibenc_interval_rtt_ms", "Round trip time of each test interval and stream in milliseconds", 1, rttBucketsMs})
+	}
+	if schema.V2 {
+		throughput = append(throughput, v2Histogram("ibenc_interval_throughput_mbps", throughputBucketsBitsPerSecond))
+		rtt = append(rtt, v2Histogram("ibenc_interval_rtt_ms", rttBucketsSeconds))
+	}
+	return throughput, rtt
+}
+
+// v2Histogram returns the v2 counterpart of a v1 histogram from V2Metrics
+func v2Histogram(v1Name string, buckets []float64) intervalHistogram {
+	v2 := V2Metrics[v1Name]
+	return intervalHistogram{v2.Name, v2Help[v2.Name], v2.Factor, buckets}
+}
+
+// scaled returns the samples in the unit of the histogram
+func (h intervalHistogram) scaled(samples []float64) []float64 {
+	if h.factor == 1 {
+		return samples
+	}
+	out := make([]float64, len(samples))
+	for i, v := range samples {
+		out[i] = v * h.factor
+	}
+	return out
+}
+
+// ExportHistogramMetrics converts the interval samples of a test to histograms
+// Each push holds the samples of one run, so they are gauge histograms: sum them over time across runs
+func ExportHistogramMetrics(result *iperf3.TestResult, labels MetricLabels, opts HistogramOptions) []*io_prometheus_client.MetricFamily {
+	timestamp := time.Now().UnixMilli()
+	throughputHistograms, rttHistograms := intervalHistograms(opts.Schema)
+
+	metrics := make([]*io_prometheus_client.MetricFamily, 0)
+
+	// Throughput histogram, one series per direction
+	for _, h := range throughputHistograms {
+		throughput := &io_prometheus_client.MetricFamily{
+			Name: stringPtr(h.name),
+			Help: stringPtr(h.help),
+			Type: io_prometheus_client.MetricType_GAUGE_HISTOGRAM.Enum(),
+		}
+		if len(result.DownloadSamplesMbps) > 0 {
+			throughput.Metric = append(throughput.Metric, newHistogram(h.scaled(result.DownloadSamplesMbps), h.buckets, opts, labels, timestamp, label{"direction", "download"}))
+		}
+		if len(result.UploadSamplesMbps) > 0 {
+			throughput.Metric = append(throughput.Metric, newHistogram(h.scaled(result.UploadSamplesMbps), h.buckets, opts, labels, timestamp, label{"direction", "upload"}))
+		}
+		if len(throughput.Metric) > 0 {
+			metrics = append(metrics, throughput)
+		}
+	}
+
+	// RTT histogram, TCP tests only
+	if len(result.RTTSamplesMs) > 0 {
+		for _, h := range rttHistograms {
+			metrics = append(metrics, &io_prometheus_client.MetricFamily{
+				Name:   stringPtr(h.name),
+				Help:   stringPtr(h.help),
+				Type:   io_prometheus_client.MetricType_GAUGE_HISTOGRAM.Enum(),
+				Metric: []*io_prometheus_client.Metric{newHistogram(h.scaled(result.RTTSamplesMs), h.buckets, opts, labels, timestamp)},
+			})
+		}
+	}
+
+	return metrics
+}
+
+// newHistogram builds a histogram of samples with classic buckets, native buckets or both
+func newHistogram(samples, bounds []float64, opts HistogramOptions, labels MetricLabels, timestamp int64, extra ...label) *io_prometheus_client.Metric {
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	h := &io_prometheus_client.Histogram{
+		SampleCount: uint64Ptr(uint64(len(samples))),
+		SampleSum:   &sum,
+	}
+
+	if opts.Classic {
+		for _, bound := range bounds {
+			count := uint64(0)
+			for _, v := range samples {
+				if v <= bound {
+					count++
+				}
+			}
+			h.Bucket = append(h.Bucket, &io_prometheus_client.Bucket{
+				UpperBound:      float64Ptr(bound),
+				CumulativeCount: uint64Ptr(count),
+			})
+		}
+	}
+
+	if opts.Native {
+		spans, deltas, zeroCount := nativeBuckets(samples, nativeSchema, nativeZeroThreshold)
+		h.Schema = int32Ptr(nativeSchema)
+		h.ZeroThreshold = float64Ptr(nativeZeroThreshold)
+		h.ZeroCount = uint64Ptr(zeroCount)
+		h.PositiveSpan = spans
+		h.PositiveDelta = deltas
+		// A no-op span marks a native histogram without observations outside the zero bucket
+		if len(spans) == 0 {
+			h.PositiveSpan = []*io_prometheus_client.BucketSpan{{Offset: int32Ptr(0), Length: uint32Ptr(0)}}
+		}
+	}
+
+	return &io_prometheus_client.Metric{
+		Label:       labels.labelPairs(extra...),
+		Histogram:   h,
+		TimestampMs: &timestamp,
+	}
+}
+
+// nativeBuckets sorts non-negative samples into exponential buckets, bucket i holding values in
+// (2^((i-1)/2^schema), 2^(i/2^schema)], and encodes them as spans of consecutive buckets with
+// counts stored as deltas to the previous bucket
+func nativeBuckets(samples []float64, schema int32, zeroThreshold float64) ([]*io_prometheus_client.BucketSpan, []int64, uint64) {
+	counts := make(map[int32]int64)
+	zeroCount := uint64(0)
+	for _, v := range samples {
+		if v <= zeroThreshold {
+			zeroCount++
+			continue
+		}
+		counts[nativeBucketIndex(v, schema)]++
+	}
+
+	indexes := make([]int32, 0, len(counts))
+	for i := range counts {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] < indexes[b] })
+
+	var spans []*io_prometheus_client.BucketSpan
+	var deltas []int64
+	var previousIndex int32
+	var previousCount int64
+	for n, i := range indexes {
+		switch {
+		case n == 0:
+			// The first span's offset is the index of its first bucket
+			spans = append(spans, &io_prometheus_client.BucketSpan{Offset: int32Ptr(i), Length: uint32Ptr(1)})
+		case i == previousIndex+1:
+			*spans[len(spans)-1].Length++
+		default:
+			// Later offsets count the empty buckets since the previous span
+			spans = append(spans, &io_prometheus_client.BucketSpan{Offset: int32Ptr(i - previousIndex - 1), Length: uint32Ptr(1)})
+		}
+		deltas = append(deltas, counts[i]-previousCount)
+		previousIndex, previousCount = i, counts[i]
+	}
+
+	return spans, deltas, zeroCount
+}
+
+// nativeBucketIndex returns the exponential bucket of a positive value
+// Frexp keeps powers of two, the bucket boundaries shared by every schema, exact
+func nativeBucketIndex(v float64, schema int32) int32 {
+	frac, exp := math.Frexp(v) // v = frac * 2^exp with frac in [0.5, 1)
+	perPowerOfTwo := float64(int32(1) << schema)
+	return int32(exp)*int32(perPowerOfTwo) + int32(math.Ceil(math.Log2(frac)*perPowerOfTwo))
+}
+
+func uint64Ptr(v uint64) *uint64 {
+	return &v
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_model/go"
+)
+
+// Schema selects the metric names and units exported, both may be set to dual-write while migrating
+type Schema struct {
+	V1 bool // original names with a metric per direction and Mbps, ms and percent units
+	V2 bool // Prometheus naming conventions, a direction label and base units
+}
+
+// V2Metric is the v2 counterpart of a v1 metric
+type V2Metric struct {
+	Name string
+	// Factor converting a v1 value to the base unit of v2, divide v2 values by it to get v1 units
+	Factor float64
+	// Label telling apart v1 metrics merged into one v2 metric, e.g. direction
+	LabelName  string
+	LabelValue string
+}
+
+// V2Metrics maps v1 metric names to v2, metrics missing from it are named the same in both schemas
+// Dashboards switch by replacing the v1 name with the v2 name and label, divided by the factor:
+//
+//	ibenc_download_speed_mbps -> ibenc_throughput_bits_per_second{direction="download"} / 1e6
+var V2Metrics = map[string]V2Metric{
+	"ibenc_download_speed_mbps": {Name: "ibenc_throughput_bits_per_second", Factor: 1e6, LabelName: "direction", LabelValue: "download"},
+	"ibenc_upload_speed_mbps":   {Name: "ibenc_throughput_bits_per_second", Factor: 1e6, LabelName: "direction", LabelValue: "upload"},
+	"ibenc_latency_ms":          {Name: "ibenc_rtt_seconds", Factor: 1e-3},
+	"ibenc_jitter_ms":           {Name: "ibenc_jitter_seconds", Factor: 1e-3},
+	"ibenc_packet_loss_percent": {Name: "ibenc_packet_loss_ratio", Factor: 1e-2},
+
+	"ibenc_cross_traffic_mbps":      {Name: "ibenc_cross_traffic_bits_per_second", Factor: 1e6},
+	"ibenc_estimated_capacity_mbps": {Name: "ibenc_estimated_capacity_bits_per_second", Factor: 1e6},
+
+	"ibenc_client_cpu_percent": {Name: "ibenc_cpu_utilization_ratio", Factor: 1e-2, LabelName: "side", LabelValue: "client"},
+	"ibenc_server_cpu_percent": {Name: "ibenc_cpu_utilization_ratio", Factor: 1e-2, LabelName: "side", LabelValue: "server"},
+	"ibenc_link_speed_mbps":    {Name: "ibenc_link_speed_bits_per_second", Factor: 1e6},
+	"ibenc_wifi_bitrate_mbps":  {Name: "ibenc_wifi_bitrate_bits_per_second", Factor: 1e6},
+
+	// Built from the samples in each schema by ExportHistogramMetrics
+	"ibenc_interval_throughput_mbps": {Name: "ibenc_interval_throughput_bits_per_second", Factor: 1e6},
+	"ibenc_interval_rtt_ms":          {Name: "ibenc_interval_rtt_seconds", Factor: 1e-3},
+}
+
+// v2Help holds the help text of v2 metrics, v1 help texts mention v1 units
+var v2Help = map[string]string{
+	"ibenc_throughput_bits_per_second":          "Throughput in bits per second",
+	"ibenc_rtt_seconds":                         "Round trip time in seconds",
+	"ibenc_jitter_seconds":                      "Jitter in seconds",
+	"ibenc_packet_loss_ratio":                   "Packet loss ratio from 0 to 1",
+	"ibenc_cross_traffic_bits_per_second":       "Non-test traffic on the WAN interface during the test in bits per second",
+	"ibenc_estimated_capacity_bits_per_second":  "Test throughput plus cross traffic in bits per second",
+	"ibenc_cpu_utilization_ratio":               "CPU utilization of iperf3 from 0 to 1",
+	"ibenc_link_speed_bits_per_second":          "Link speed of the client interface in bits per second",
+	"ibenc_wifi_bitrate_bits_per_second":        "Wi-Fi bitrate of the client in bits per second",
+	"ibenc_interval_throughput_bits_per_second": "Throughput of each test interval in bits per second",
+	"ibenc_interval_rtt_seconds":                "Round trip time of each test interval and stream in seconds",
+}
+
+// ApplySchema converts v1 metric families to the selected schemas
+// Metrics named the same in both schemas are kept once, histograms are passed through as
+// ExportHistogramMetrics already builds them per schema
+func ApplySchema(families []*io_prometheus_client.MetricFamily, schema Schema) []*io_prometheus_client.MetricFamily {
+	if !schema.V2 {
+		return families
+	}
+
+	result := make([]*io_prometheus_client.MetricFamily, 0, len(families))
+	merged := make(map[string]*io_prometheus_client.MetricFamily)
+
+	for _, mf := range families {
+		v2, ok := V2Metrics[mf.GetName()]
+		if !ok || isHistogram(mf) {
+			result = append(result, mf)
+			continue
+		}
+		if schema.V1 {
+			result = append(result, mf)
+		}
+
+		// v1 metrics merged into one v2 metric share a family
+		converted, exists := merged[v2.Name]
+		if !exists {
+			converted = &io_prometheus_client.MetricFamily{
+				Name: stringPtr(v2.Name),
+				Help: stringPtr(v2Help[v2.Name]),
+				Type: mf.Type,
+			}
+			merged[v2.Name] = converted
+			result = append(result, converted)
+		}
+		for _, m := range mf.Metric {
+			converted.Metric = append(converted.Metric, convertMetric(m, v2))
+		}
+	}
+
+	return result
+}
+
+// convertMetric copies a gauge to v2 units, adding the label of merged metrics
+func convertMetric(m *io_prometheus_client.Metric, v2 V2Metric) *io_prometheus_client.Metric {
+	labelPairs := m.Label
+	if v2.LabelName != "" {
+		// Inserted in order, remote write expects sorted labels
+		at, _ := slices.BinarySearchFunc(m.Label, v2.LabelName, func(lp *io_prometheus_client.LabelPair, name string) int {
+			return strings.Compare(lp.GetName(), name)
+		})
+		labelPairs = slices.Insert(slices.Clone(m.Label), at, &io_prometheus_client.LabelPair{
+			Name:  stringPtr(v2.LabelName),
+			Value: stringPtr(v2.LabelValue),
+		})
+	}
+
+	return &io_prometheus_client.Metric{
+		Label:       labelPairs,
+		Gauge:       &io_prometheus_client.Gauge{Value: float64Ptr(m.GetGauge().GetValue() * v2.Factor)},
+		TimestampMs: m.TimestampMs,
+	}
+}
+
+// isHistogram reports whether a family holds histograms
+func isHistogram(mf *io_prometheus_client.MetricFamily) bool {
+	return mf.GetType() == io_prometheus_client.MetricType_HISTOGRAM || mf.GetType() == io_prometheus_client.MetricType_GAUGE_HISTOGRAM
+}
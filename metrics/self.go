@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_model/go"
+)
+
+// RunStats describes the health of a single ibenc run
+type RunStats struct {
+	Duration         time.Duration // wall time of the iperf3 tests
+	Success          bool
+	DownloadAttempts int
+	UploadAttempts   int
+	Version          string // ibenc version
+	Iperf3Version    string
+}
+
+// ExportSelfMetrics converts run statistics to Prometheus metrics about ibenc itself
+func ExportSelfMetrics(stats RunStats, labels MetricLabels) []*io_prometheus_client.MetricFamily {
+	timestamp := time.Now().UnixMilli()
+
+	success := 0.0
+	if stats.Success {
+		success = 1
+	}
+
+	iperf3Version := stats.Iperf3Version
+	if iperf3Version == "" {
+		iperf3Version = "unknown"
+	}
+
+	metrics := make([]*io_prometheus_client.MetricFamily, 0)
+
+	// Run duration metric
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_run_duration_seconds",
+		"Duration of the iperf3 tests in the last run in seconds",
+		stats.Duration.Seconds(),
+		labels,
+		timestamp,
+	))
+
+	// Run success metric
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_run_success",
+		"Whether the last run produced results (1) or failed (0)",
+		success,
+		labels,
+		timestamp,
+	))
+
+	// Attempts metric, one series per direction
+	// Each push reports the attempts of that run, a gauge, so there is no _total suffix
+	metrics = append(metrics, createDirectionalGaugeMetric(
+		"ibenc_test_attempts",
+		"Number of iperf3 attempts made in the last run",
+		float64(stats.DownloadAttempts),
+		float64(stats.UploadAttempts),
+		labels,
+		timestamp,
+	))
+
+	// Build info metric
+	metrics = append(metrics, createGaugeMetric(
+		"ibenc_build_info",
+		"Build information of ibenc and the iperf3 binary it runs",
+		1,
+		labels,
+		timestamp,
+		label{"version", stats.Version},
+		label{"iperf3_version", iperf3Version},
+	))
+
+	return metrics
+}
+
+// ExportRemoteWriteMetrics creates the remote write duration metric
+// It is sent in a follow-up request since the duration is only known after the first one
+func ExportRemoteWriteMetrics(duration time.Duration, labels MetricLabels) []*io_prometheus_client.MetricFamily {
+	return []*io_prometheus_client.MetricFamily{
+		createGaugeMetric(
+			"ibenc_remote_write_duration_seconds",
+			"Duration of the last remote write request in seconds",
+			duration.Seconds(),
+			labels,
+			time.Now().UnixMilli(),
+		),
+	}
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_model/go"
+)
+
+// Escapers of the text exposition format, HELP text leaves quotes alone
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteText writes metrics in the Prometheus text exposition format
+// Families sharing a name are merged since the format allows each name once,
+// timestamps are left out when withTimestamps is false as node_exporter requires
+func WriteText(w io.Writer, families []*io_prometheus_client.MetricFamily, withTimestamps bool) error {
+	bw := bufio.NewWriter(w)
+
+	for _, mf := range MergeFamilies(families) {
+		name := mf.GetName()
+		if !hasTextSamples(mf) {
+			continue
+		}
+		if mf.Help != nil {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, helpEscaper.Replace(mf.GetHelp()))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, textType(mf.GetType()))
+
+		for _, m := range mf.Metric {
+			timestamp := m.TimestampMs
+			if !withTimestamps {
+				timestamp = nil
+			}
+
+			switch {
+			case m.Gauge != nil:
+				writeSample(bw, name, m.Label, m.Gauge.GetValue(), timestamp)
+			case m.Counter != nil:
+				writeSample(bw, name, m.Label, m.Counter.GetValue(), timestamp)
+			case m.Untyped != nil:
+				writeSample(bw, name, m.Label, m.Untyped.GetValue(), timestamp)
+			case m.Histogram != nil && len(m.Histogram.Bucket) > 0:
+				// Only classic buckets have a text representation
+				h := m.Histogram
+				for _, b := range h.Bucket {
+					writeSample(bw, name+"_bucket", withLabel(m.Label, "le", formatFloat(b.GetUpperBound())), float64(b.GetCumulativeCount()), timestamp)
+				}
+				writeSample(bw, name+"_bucket", withLabel(m.Label, "le", "+Inf"), float64(h.GetSampleCount()), timestamp)
+				writeSample(bw, name+"_sum", m.Label, h.GetSampleSum(), timestamp)
+				writeSample(bw, name+"_count", m.Label, float64(h.GetSampleCount()), timestamp)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// hasTextSamples reports whether a family has samples the text format can carry,
+// native histograms without classic buckets have none
+func hasTextSamples(mf *io_prometheus_client.MetricFamily) bool {
+	for _, m := range mf.Metric {
+		if m.Histogram == nil || len(m.Histogram.Bucket) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// textType returns the TYPE of a family, gauge histograms are written as histograms
+// since only OpenMetrics knows them
+func textType(t io_prometheus_client.MetricType) string {
+	if t == io_prometheus_client.MetricType_GAUGE_HISTOGRAM {
+		return "histogram"
+	}
+	return strings.ToLower(t.String())
+}
+
+// writeSample writes a sample line, with a timestamp when it is not nil
+func writeSample(w *bufio.Writer, name string, labels []*io_prometheus_client.LabelPair, value float64, timestamp *int64) {
+	w.WriteString(name)
+	writeLabels(w, labels)
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	if timestamp != nil {
+		fmt.Fprintf(w, " %d", *timestamp)
+	}
+	w.WriteByte('\n')
+}
+
+// withLabel returns the labels with one more appended
+func withLabel(labels []*io_prometheus_client.LabelPair, name, value string) []*io_prometheus_client.LabelPair {
+	all := make([]*io_prometheus_client.LabelPair, 0, len(labels)+1)
+	all = append(all, labels...)
+	return append(all, &io_prometheus_client.LabelPair{Name: &name, Value: &value})
+}
+
+// MergeFamilies combines families with the same name, keeping the order names first appear in
+func MergeFamilies(families []*io_prometheus_client.MetricFamily) []*io_prometheus_client.MetricFamily {
+	merged := make([]*io_prometheus_client.MetricFamily, 0, len(families))
+	byName := make(map[string]*io_prometheus_client.MetricFamily)
+
+	for _, mf := range families {
+		if existing, ok := byName[mf.GetName()]; ok {
+			existing.Metric = append(existing.Metric, mf.Metric...)
+			continue
+		}
+		// Copied so merging doesn't modify the caller's families
+		c := &io_prometheus_client.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+		c.Metric = append(c.Metric, mf.Metric...)
+		byName[mf.GetName()] = c
+		merged = append(merged, c)
+	}
+
+	return merged
+}
+
+// writeLabels writes a label set in braces, nothing when it is empty
+func writeLabels(w *bufio.Writer, labels []*io_prometheus_client.LabelPair) {
+	// The text format has no exemplars for gauges, run IDs are left out
+	labels = slices.DeleteFunc(slices.Clone(labels), func(lp *io_prometheus_client.LabelPair) bool {
+		return lp.GetName() == TraceIDLabel
+	})
+	if len(labels) == 0 {
+		return
+	}
+
+	w.WriteByte('{')
+	for i, lp := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, `%s="%s"`, lp.GetName(), labelValueEscaper.Replace(lp.GetValue()))
+	}
+	w.WriteByte('}')
+}
+
+// formatFloat formats a sample value, with the spelling of special values the format expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
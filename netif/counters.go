@@ -0,0 +1,147 @@
+package netif
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// procNetDev is the kernel's per-interface traffic counter table
+const procNetDev = "/proc/net/dev"
+
+// Counters holds the byte counters of an interface
+type Counters struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// ReadCounters returns the current byte counters of an interface from /proc/net/dev
+func ReadCounters(iface string) (Counters, error) {
+	f, err := os.Open(procNetDev)
+	if err != nil {
+		return Counters{}, fmt.Errorf("failed to read interface counters: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines look like "  eth0: 1234 56 0 0 0 0 0 0 7890 12 0 0 0 0 0 0"
+		name, stats, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) != iface {
+			continue
+		}
+
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			return Counters{}, fmt.Errorf("unexpected %s line for %s", procNetDev, iface)
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return Counters{}, fmt.Errorf("invalid rx bytes for %s: %w", iface, err)
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return Counters{}, fmt.Errorf("invalid tx bytes for %s: %w", iface, err)
+		}
+		return Counters{RxBytes: rx, TxBytes: tx}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return Counters{}, fmt.Errorf("failed to read interface counters: %w", err)
+	}
+
+	return Counters{}, fmt.Errorf("interface %s not found in %s", iface, procNetDev)
+}
+
+// Usage is the traffic seen on an interface while a monitor was running
+type Usage struct {
+	RxBytes uint64
+	TxBytes uint64
+	Elapsed time.Duration
+}
+
+// Monitor samples interface counters before, during and after a test
+type Monitor struct {
+	iface   string
+	start   Counters
+	started time.Time
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	mu   sync.Mutex
+	last Counters
+	err  error
+}
+
+// StartMonitor takes the first sample and keeps sampling every interval until Stop
+func StartMonitor(iface string, interval time.Duration) (*Monitor, error) {
+	start, err := ReadCounters(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Monitor{
+		iface:   iface,
+		start:   start,
+		started: time.Now(),
+		stop:    make(chan struct{}),
+		last:    start,
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+
+	return m, nil
+}
+
+// Stop takes the final sample and returns the traffic since the monitor started
+func (m *Monitor) Stop() (Usage, error) {
+	close(m.stop)
+	m.wg.Wait()
+	m.sample()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return Usage{}, m.err
+	}
+	return Usage{
+		RxBytes: m.last.RxBytes - m.start.RxBytes,
+		TxBytes: m.last.TxBytes - m.start.TxBytes,
+		Elapsed: time.Since(m.started),
+	}, nil
+}
+
+// sample reads the counters, catching interface resets that would make the totals meaningless
+func (m *Monitor) sample() {
+	counters, err := ReadCounters(m.iface)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.err != nil:
+	case err != nil:
+		m.err = err
+	case counters.RxBytes < m.last.RxBytes || counters.TxBytes < m.last.TxBytes:
+		m.err = fmt.Errorf("counters of %s were reset during the test", m.iface)
+	default:
+		m.last = counters
+	}
+}
@@ -0,0 +1,134 @@
+package netif
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sysClassNet holds one directory of attributes per interface
+	sysClassNet = "/sys/class/net"
+
+	// procNetWireless lists signal statistics of wireless interfaces
+	procNetWireless = "/proc/net/wireless"
+
+	// procNetRoute is the kernel's IPv4 routing table
+	procNetRoute = "/proc/net/route"
+)
+
+// Wireless holds the radio statistics of a Wi-Fi interface
+type Wireless struct {
+	SignalDBm   float64 // received signal level
+	LinkQuality float64 // driver specific link quality
+	BitrateMbps float64 // current transmit bitrate, 0 when iw is not available
+}
+
+// LinkSpeed returns the negotiated speed of a wired interface in Mbps
+func LinkSpeed(iface string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(sysClassNet, iface, "speed"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read link speed of %s: %w", iface, err)
+	}
+
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid link speed of %s: %w", iface, err)
+	}
+	// Virtual interfaces and links that are down report -1
+	if speed <= 0 {
+		return 0, fmt.Errorf("link speed of %s is unknown", iface)
+	}
+
+	return speed, nil
+}
+
+// ReadWireless returns the radio statistics of an interface,
+// ok is false when the interface is not a Wi-Fi interface
+func ReadWireless(iface string) (w Wireless, ok bool, err error) {
+	f, err := os.Open(procNetWireless)
+	if os.IsNotExist(err) {
+		return Wireless{}, false, nil
+	}
+	if err != nil {
+		return Wireless{}, false, fmt.Errorf("failed to read wireless statistics: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines look like "wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0"
+		name, stats, found := strings.Cut(scanner.Text(), ":")
+		if !found || strings.TrimSpace(name) != iface {
+			continue
+		}
+
+		fields := strings.Fields(stats)
+		if len(fields) < 3 {
+			return Wireless{}, false, fmt.Errorf("unexpected %s line for %s", procNetWireless, iface)
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64)
+		if err != nil {
+			return Wireless{}, false, fmt.Errorf("invalid link quality for %s: %w", iface, err)
+		}
+		signal, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			return Wireless{}, false, fmt.Errorf("invalid signal level for %s: %w", iface, err)
+		}
+
+		w = Wireless{SignalDBm: signal, LinkQuality: quality}
+		w.BitrateMbps, _ = wirelessBitrate(iface)
+		return w, true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return Wireless{}, false, fmt.Errorf("failed to read wireless statistics: %w", err)
+	}
+
+	return Wireless{}, false, nil
+}
+
+// iwBitrate matches the transmit bitrate in "iw dev <iface> link" output
+var iwBitrate = regexp.MustCompile(`tx bitrate:\s*([0-9.]+)\s*MBit/s`)
+
+// wirelessBitrate asks iw for the current transmit bitrate, /proc/net/wireless doesn't carry it
+func wirelessBitrate(iface string) (float64, error) {
+	output, err := exec.Command("iw", "dev", iface, "link").Output()
+	if err != nil {
+		return 0, fmt.Errorf("iw dev %s link failed: %w", iface, err)
+	}
+
+	match := iwBitrate.FindSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("no tx bitrate for %s", iface)
+	}
+
+	return strconv.ParseFloat(string(match[1]), 64)
+}
+
+// DefaultInterface returns the interface of the IPv4 default route
+func DefaultInterface() (string, error) {
+	f, err := os.Open(procNetRoute)
+	if err != nil {
+		return "", fmt.Errorf("failed to read routing table: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Columns are Iface, Destination, Gateway, ... with the header on the first line
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read routing table: %w", err)
+	}
+
+	return "", fmt.Errorf("no default route")
+}
@@ -0,0 +1,79 @@
+package remote
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// histogramSeries converts a histogram to remote write series: a native histogram under the
+// metric name, and classic buckets as _bucket, _sum and _count series, whichever it has
+// labels start with __name__ as built by WriteMetrics, gauge histograms hold observations of one period
+func histogramSeries(labels []prompb.Label, h *io_prometheus_client.Histogram, gauge bool, timestamp int64) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	name := labels[0].Value
+
+	if h.Schema != nil {
+		series = append(series, prompb.TimeSeries{
+			Labels:     labels,
+			Histograms: []prompb.Histogram{nativeHistogram(h, gauge, timestamp)},
+		})
+	}
+
+	if len(h.Bucket) > 0 {
+		sample := func(suffix string, value float64, extra ...prompb.Label) {
+			seriesLabels := make([]prompb.Label, 0, len(labels)+len(extra))
+			seriesLabels = append(seriesLabels, prompb.Label{Name: "__name__", Value: name + suffix})
+			seriesLabels = append(seriesLabels, labels[1:]...)
+			seriesLabels = append(seriesLabels, extra...)
+			// Receivers expect labels sorted by name, le lands among the others
+			sort.Slice(seriesLabels, func(i, j int) bool { return seriesLabels[i].Name < seriesLabels[j].Name })
+
+			series = append(series, prompb.TimeSeries{
+				Labels:  seriesLabels,
+				Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+			})
+		}
+
+		for _, b := range h.Bucket {
+			sample("_bucket", float64(b.GetCumulativeCount()), prompb.Label{Name: "le", Value: formatBound(b.GetUpperBound())})
+		}
+		sample("_bucket", float64(h.GetSampleCount()), prompb.Label{Name: "le", Value: "+Inf"})
+		sample("_sum", h.GetSampleSum())
+		sample("_count", float64(h.GetSampleCount()))
+	}
+
+	return series
+}
+
+// nativeHistogram converts the native buckets of a histogram to its remote write form
+func nativeHistogram(h *io_prometheus_client.Histogram, gauge bool, timestamp int64) prompb.Histogram {
+	spans := make([]prompb.BucketSpan, 0, len(h.PositiveSpan))
+	for _, span := range h.PositiveSpan {
+		spans = append(spans, prompb.BucketSpan{Offset: span.GetOffset(), Length: span.GetLength()})
+	}
+
+	hint := prompb.Histogram_UNKNOWN
+	if gauge {
+		hint = prompb.Histogram_GAUGE
+	}
+
+	return prompb.Histogram{
+		Count:          &prompb.Histogram_CountInt{CountInt: h.GetSampleCount()},
+		Sum:            h.GetSampleSum(),
+		Schema:         h.GetSchema(),
+		ZeroThreshold:  h.GetZeroThreshold(),
+		ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: h.GetZeroCount()},
+		PositiveSpans:  spans,
+		PositiveDeltas: h.PositiveDelta,
+		ResetHint:      hint,
+		Timestamp:      timestamp,
+	}
+}
+
+// formatBound formats a bucket upper bound as the le label value
+func formatBound(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/prompb"
+	"ibenc/metrics"
 )
 
 // Config holds Grafana Cloud authentication and endpoint details
@@ -21,6 +22,22 @@ type Config struct {
 	Password      string // API token
 }
 
+// StatusError is returned when the remote write endpoint answers with a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote write failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether sending the same request again may succeed
+// Other 4xx statuses mean the request itself was rejected, e.g. out of order samples
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
 // Writer sends metrics to Grafana Cloud via remote write API
 type Writer struct {
 	config Config
@@ -38,11 +55,11 @@ func NewWriter(config Config) *Writer {
 }
 
 // WriteMetrics sends the metrics to Grafana Cloud using Prometheus remote write protocol
-func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) error {
+func (w *Writer) WriteMetrics(families []*io_prometheus_client.MetricFamily) error {
 	// Convert MetricFamily to Prometheus remote write format
 	timeseries := make([]prompb.TimeSeries, 0)
 
-	for _, mf := range metrics {
+	for _, mf := range families {
 		for _, m := range mf.Metric {
 			// Build labels
 			labels := make([]prompb.Label, 0)
@@ -53,15 +70,26 @@ func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) erro
 				Value: *mf.Name,
 			})
 
-			// Add all other labels
+			// Add all other labels, the run ID becomes an exemplar
+			var traceID string
 			for _, lp := range m.Label {
+				if lp.GetName() == metrics.TraceIDLabel {
+					traceID = lp.GetValue()
+					continue
+				}
 				labels = append(labels, prompb.Label{
 					Name:  *lp.Name,
 					Value: *lp.Value,
 				})
 			}
 
-			// Extract value and timestamp
+			// Use the sample timestamp so queued metrics keep their measurement time
+			timestamp := time.Now().UnixMilli()
+			if m.TimestampMs != nil {
+				timestamp = *m.TimestampMs
+			}
+
+			// Extract value
 			var value float64
 			switch {
 			case m.Gauge != nil:
@@ -70,13 +98,13 @@ func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) erro
 				value = *m.Counter.Value
 			case m.Untyped != nil:
 				value = *m.Untyped.Value
+			case m.Histogram != nil:
+				timeseries = append(timeseries, histogramSeries(labels, m.Histogram, mf.GetType() == io_prometheus_client.MetricType_GAUGE_HISTOGRAM, timestamp)...)
+				continue
 			default:
 				continue
 			}
 
-			// Use current time in milliseconds
-			timestamp := time.Now().UnixMilli()
-
 			// Create time series
 			ts := prompb.TimeSeries{
 				Labels: labels,
@@ -87,6 +115,13 @@ func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) erro
 					},
 				},
 			}
+			if traceID != "" {
+				ts.Exemplars = []prompb.Exemplar{{
+					Labels:    []prompb.Label{{Name: "trace_id", Value: traceID}},
+					Value:     value,
+					Timestamp: timestamp,
+				}}
+			}
 
 			timeseries = append(timeseries, ts)
 		}
@@ -150,7 +185,7 @@ func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) erro
 				}
 			}
 
-			return fmt.Errorf("remote write failed with status %d: %s", resp.StatusCode, errMsg)
+			return &StatusError{StatusCode: resp.StatusCode, Body: errMsg}
 		}
 
 		return nil
@@ -158,3 +193,8 @@ func (w *Writer) WriteMetrics(metrics []*io_prometheus_client.MetricFamily) erro
 
 	return fmt.Errorf("failed to send metrics after %d retries", maxRetries)
 }
+
+// Ping sends an empty remote write request to check the endpoint and credentials
+func (w *Writer) Ping() error {
+	return w.WriteMetrics(nil)
+}
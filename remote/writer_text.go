@@ -6,60 +6,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 
 	"github.com/prometheus/client_model/go"
+	"ibenc/metrics"
 )
 
 // WriteMetricsText sends metrics using Prometheus text exposition format (alternative to protobuf)
-func (w *Writer) WriteMetricsText(metrics []*io_prometheus_client.MetricFamily) error {
+func (w *Writer) WriteMetricsText(families []*io_prometheus_client.MetricFamily) error {
 	buffer := &bytes.Buffer{}
-
-	for _, mf := range metrics {
-		// Write HELP line
-		if mf.Help != nil {
-			fmt.Fprintf(buffer, "# HELP %s %s\n", *mf.Name, *mf.Help)
-		}
-		// Write TYPE line
-		if mf.Type != nil {
-			typeStr := mf.Type.String()
-			// Convert type to prometheus format
-			promType := "gauge"
-			switch typeStr {
-			case "COUNTER":
-				promType = "counter"
-			case "HISTOGRAM":
-				promType = "histogram"
-			case "SUMMARY":
-				promType = "summary"
-			}
-			fmt.Fprintf(buffer, "# TYPE %s %s\n", *mf.Name, promType)
-		}
-
-		// Write metric samples
-		for _, m := range mf.Metric {
-			metricName := *mf.Name
-			labels := buildLabelsString(m.Label)
-			var value float64
-
-			switch {
-			case m.Gauge != nil:
-				value = *m.Gauge.Value
-			case m.Counter != nil:
-				value = *m.Counter.Value
-			case m.Untyped != nil:
-				value = *m.Untyped.Value
-			default:
-				continue
-			}
-
-			timestamp := time.Now().UnixMilli()
-			if m.TimestampMs != nil {
-				timestamp = *m.TimestampMs
-			}
-
-			fmt.Fprintf(buffer, "%s%s %v %d\n", metricName, labels, value, timestamp)
-		}
+	if err := metrics.WriteText(buffer, families, true); err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
 	}
 
 	// Create HTTP request to the text format endpoint
@@ -90,21 +46,3 @@ func (w *Writer) WriteMetricsText(metrics []*io_prometheus_client.MetricFamily)
 
 	return nil
 }
-
-// buildLabelsString creates label string from label pairs
-func buildLabelsString(labelPairs []*io_prometheus_client.LabelPair) string {
-	if len(labelPairs) == 0 {
-		return ""
-	}
-
-	labelsStr := "{"
-	for i, lp := range labelPairs {
-		if i > 0 {
-			labelsStr += ","
-		}
-		labelsStr += fmt.Sprintf(`%s="%s"`, *lp.Name, *lp.Value)
-	}
-	labelsStr += "}"
-
-	return labelsStr
-}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/config"
+	"ibenc/history"
+	"ibenc/iperf3"
+	"ibenc/metrics"
+	"ibenc/remote"
+	"ibenc/textfile"
+)
+
+// targetRun is the outcome of testing a target over one address family
+type targetRun struct {
+	id     string // run ID, links metrics and history to the raw iperf3 output
+	target config.TargetConfig
+	time   time.Time
+	result *iperf3.TestResult // nil when no test ran
+	err    error
+}
+
+// runTarget runs the iperf3 tests of a target, once per address family with
+// address_family both, and returns the metrics to send along with each test's outcome
+// onInterval, when set, receives the progress of tests as iperf3 reports it
+func runTarget(cfg *config.Config, target config.TargetConfig, onInterval func(iperf3.Interval)) ([]*io_prometheus_client.MetricFamily, []targetRun, error) {
+	var metricsData []*io_prometheus_client.MetricFamily
+	var runs []targetRun
+	var lastErr error
+
+	for _, variant := range target.AddressFamilies() {
+		runID := history.NewRunID()
+		start := time.Now()
+		variantMetrics, result, err := runAddressFamily(cfg, variant, runID, onInterval)
+		metricsData = append(metricsData, metrics.ApplySchema(variantMetrics, metricSchema(cfg))...)
+		runs = append(runs, targetRun{id: runID, target: variant, time: start, result: result, err: err})
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return metricsData, runs, lastErr
+}
+
+// runAddressFamily runs the iperf3 tests of a target over a single address family
+// When the test fails the returned metrics only describe ibenc itself
+func runAddressFamily(cfg *config.Config, target config.TargetConfig, runID string, onInterval func(iperf3.Interval)) ([]*io_prometheus_client.MetricFamily, *iperf3.TestResult, error) {
+	switch {
+	case target.Name != "" && target.IPVersion() != "":
+		log.Printf("Running target %s over IPv%s\n", target.Name, target.IPVersion())
+	case target.Name != "":
+		log.Printf("Running target %s\n", target.Name)
+	case target.IPVersion() != "":
+		log.Printf("Running over IPv%s\n", target.IPVersion())
+	}
+
+	metricLabels := newMetricLabels(cfg, target)
+	metricLabels.RunID = runID
+
+	iperf3Version, err := iperf3.Version()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	// Progress needs --json-stream, older iperf3 only reports at the end
+	if onInterval != nil && !iperf3.SupportsJSONStream(iperf3Version) {
+		onInterval = nil
+	}
+
+	// Only one test per uplink at a time, on this host and with lock.server on the LAN
+	lock, err := acquireRunLock(cfg, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire run lock: %w", err)
+	}
+	defer lock.release()
+
+	// Fit the test into the data budget of metered connections
+	plan := planBudget(cfg, target)
+	if plan != nil && plan.skip {
+		log.Println("Skipping test, it doesn't fit in the data budget")
+		plan.record(nil)
+		return plan.metrics(metricLabels), nil, nil
+	}
+	if plan != nil {
+		target.Duration = plan.duration
+	}
+
+	// Run iperf3 tests
+	log.Printf("Run ID: %s\n", runID)
+	start := time.Now()
+	testResult, err := runServers(cfg, target, onInterval)
+	// Another instance may have tested the uplink at the same time, its traffic skews the result
+	lostErr := lock.lost()
+	discarded := err == nil && lostErr != nil
+	if discarded {
+		err = fmt.Errorf("results discarded: %w", lostErr)
+	}
+	saveRawResult(cfg, runID, target, start, testResult, err)
+	var budgetMetrics []*io_prometheus_client.MetricFamily
+	if plan != nil {
+		plan.record(testResult)
+		budgetMetrics = plan.metrics(metricLabels)
+	}
+	stats := metrics.RunStats{
+		Duration:         time.Since(start),
+		Success:          err == nil && (testResult.DownloadMbps > 0 || testResult.UploadMbps > 0),
+		DownloadAttempts: testResult.DownloadAttempts,
+		UploadAttempts:   testResult.UploadAttempts,
+		Version:          version,
+		Iperf3Version:    iperf3Version,
+	}
+	// A discarded result says nothing about the servers, only their own failures expire the selection
+	if usesAutoServer(target) && !discarded {
+		reviewSelection(cfg, target, testResult, stats.Success)
+	}
+	if err != nil {
+		evaluateAlerts(cfg, target, nil)
+		return append(metrics.ExportSelfMetrics(stats, metricLabels), budgetMetrics...), testResult, err
+	}
+
+	log.Printf("Test Results:")
+	log.Printf("  Download: %.2f Mbps\n", testResult.DownloadMbps)
+	log.Printf("  Upload: %.2f Mbps\n", testResult.UploadMbps)
+	log.Printf("  Latency: %.2f ms\n", testResult.LatencyMs)
+	log.Printf("  Jitter: %.2f ms\n", testResult.JitterMs)
+	log.Printf("  Packet Loss: %.2f %%\n", testResult.PacketLossPercent)
+	if cfg.Bottleneck.Enabled {
+		log.Printf("  Client CPU: %.1f %%\n", testResult.ClientCPUPercent)
+	}
+	if testResult.CrossTrafficMeasured {
+		log.Printf("  Cross Traffic: %.2f Mbps down, %.2f Mbps up\n", testResult.DownloadCrossTrafficMbps, testResult.UploadCrossTrafficMbps)
+	}
+
+	// Local alerting works even when Grafana Cloud is unreachable
+	evaluateAlerts(cfg, target, testResult)
+
+	// Check if test produced any meaningful results
+	// Only send measurements if we got at least some valid data
+	if !stats.Success {
+		log.Println("❌ Error: Test results are 0 - iperf3 connection failed")
+		log.Println("   This usually means:")
+		log.Println("   - iperf3 server is unreachable")
+		log.Println("   - Firewall is blocking port 5201")
+		log.Println("   - Network connectivity issue")
+		log.Println("   Run 'ibenc servers probe' to find a reachable server.")
+		log.Println("")
+		log.Println("   No measurement metrics will be sent. Fix the connection and try again.")
+		return append(metrics.ExportSelfMetrics(stats, metricLabels), budgetMetrics...), testResult, fmt.Errorf("test results are 0")
+	}
+
+	// Create metrics
+	metricsData := metrics.ExportMetrics(testResult, metricLabels)
+	metricsData = append(metricsData, metrics.ExportSelfMetrics(stats, metricLabels)...)
+	metricsData = append(metricsData, budgetMetrics...)
+
+	if cfg.Metrics.Histograms != "" {
+		metricsData = append(metricsData, metrics.ExportHistogramMetrics(testResult, metricLabels, metrics.HistogramOptions{
+			Classic: cfg.Metrics.Histograms == "classic" || cfg.Metrics.Histograms == "both",
+			Native:  cfg.Metrics.Histograms == "native" || cfg.Metrics.Histograms == "both",
+			Schema:  metricSchema(cfg),
+		})...)
+	}
+
+	if cfg.Bottleneck.Enabled {
+		metricsData = append(metricsData, clientMetrics(cfg, target, testResult, metricLabels)...)
+	}
+
+	if testResult.CrossTrafficMeasured {
+		if metrics.CrossTrafficExceeded(testResult, cfg.CrossTraffic.ThresholdMbps) {
+			log.Printf("Warning: cross traffic exceeded %.2f Mbps, results under-report the line", cfg.CrossTraffic.ThresholdMbps)
+		}
+		metricsData = append(metricsData, metrics.ExportCrossTrafficMetrics(testResult, metricLabels, metrics.CrossTrafficOptions{
+			EstimateCapacity: cfg.CrossTraffic.EstimateCapacity,
+			ThresholdMbps:    cfg.CrossTraffic.ThresholdMbps,
+		})...)
+	}
+
+	return metricsData, testResult, nil
+}
+
+// runServers tries the servers of a target in order until one of them produces results
+func runServers(cfg *config.Config, target config.TargetConfig, onInterval func(iperf3.Interval)) (*iperf3.TestResult, error) {
+	result := &iperf3.TestResult{}
+	err := fmt.Errorf("no servers configured")
+	downloadAttempts, uploadAttempts := 0, 0
+
+	for _, ep := range targetEndpoints(cfg, target) {
+		if target.WAN != "" {
+			log.Printf("Starting iperf3 benchmark against %s:%d via %s\n", ep.host, ep.port, target.WAN)
+		} else {
+			log.Printf("Starting iperf3 benchmark against %s:%d\n", ep.host, ep.port)
+		}
+
+		opts := targetOptions(cfg, target, ep)
+		opts.OnInterval = onInterval
+		result, err = iperf3.RunBothTestsWithOptions(opts)
+		downloadAttempts += result.DownloadAttempts
+		uploadAttempts += result.UploadAttempts
+		if err == nil {
+			break
+		}
+		log.Printf("Warning: test against %s failed: %v", ep.host, err)
+	}
+
+	// Attempts cover every server tried
+	result.DownloadAttempts = downloadAttempts
+	result.UploadAttempts = uploadAttempts
+
+	return result, err
+}
+
+// targetOptions converts a target to iperf3 client options for one of its servers
+func targetOptions(cfg *config.Config, target config.TargetConfig, ep endpoint) iperf3.Options {
+	opts := iperf3.Options{
+		Server:   ep.host,
+		Port:     ep.port,
+		Duration: target.Duration,
+		Parallel: target.Parallel,
+		UDP:      target.Protocol == "udp",
+		Bitrate:  target.Bitrate,
+
+		AddressFamily: target.AddressFamily,
+		BindAddress:   target.BindAddress,
+		Interface:     target.Interface,
+	}
+
+	// Cross traffic is measured on the configured WAN interface, or the one the target is bound to
+	if cfg.CrossTraffic.Enabled {
+		opts.MonitorInterface = cfg.CrossTraffic.Interface
+		if opts.MonitorInterface == "" {
+			opts.MonitorInterface = target.Interface
+		}
+		if opts.MonitorInterface == "" {
+			log.Println("Warning: cross traffic not measured: set cross_traffic.interface or the target interface")
+		}
+	}
+
+	return opts
+}
+
+// sendMetrics writes metrics to the textfile collector and sends them to Grafana Cloud followed by
+// the remote write duration, whichever of the two is configured
+func sendMetrics(cfg *config.Config, metricsData []*io_prometheus_client.MetricFamily) error {
+	if cfg.Textfile.Directory != "" {
+		log.Printf("Writing metrics to %s\n", cfg.Textfile.Directory)
+		if err := textfile.Write(cfg.Textfile.Directory, metricsData); err != nil {
+			return err
+		}
+	}
+	if cfg.Prometheus.URL == "" {
+		return nil
+	}
+
+	writer := remote.NewWriter(remote.Config{
+		PrometheusURL: cfg.Prometheus.URL,
+		Username:      cfg.Prometheus.Username,
+		Password:      cfg.Prometheus.Password,
+	})
+
+	log.Printf("Sending metrics to %s\n", cfg.Prometheus.URL)
+	writeStart := time.Now()
+	if err := writer.WriteMetrics(metricsData); err != nil {
+		return err
+	}
+
+	metricLabels := newMetricLabels(cfg, config.TargetConfig{})
+	if err := writer.WriteMetrics(metrics.ExportRemoteWriteMetrics(time.Since(writeStart), metricLabels)); err != nil {
+		log.Printf("Warning: failed to send self metrics: %v", err)
+	}
+
+	return nil
+}
+
+// metricSchema returns the metric schemas selected by metrics.schema
+func metricSchema(cfg *config.Config) metrics.Schema {
+	return metrics.Schema{
+		V1: cfg.Metrics.Schema != "v2",
+		V2: cfg.Metrics.Schema == "v2" || cfg.Metrics.Schema == "both",
+	}
+}
+
+// newMetricLabels builds the labels attached to every series of a target
+func newMetricLabels(cfg *config.Config, target config.TargetConfig) metrics.MetricLabels {
+	labels := metrics.MetricLabels{
+		Location:    cfg.Metrics.Location,
+		ISPName:     cfg.Metrics.ISPName,
+		PackageName: cfg.Metrics.PackageName,
+		Target:      target.Name,
+		WAN:         target.WAN,
+		Custom:      cfg.CustomLabels(target),
+	}
+	// A pinned family keeps the series of the target, only dual-stack runs need telling apart
+	if target.DualStack {
+		labels.IPVersion = target.IPVersion()
+	}
+	return labels
+}
+
+// targetStateFile returns the name of a state file kept per target and address family
+func targetStateFile(kind string, target config.TargetConfig) string {
+	parts := []string{kind}
+	if target.Name != "" {
+		parts = append(parts, target.Name)
+	}
+	if target.IPVersion() != "" {
+		parts = append(parts, "ipv"+target.IPVersion())
+	}
+	return strings.Join(parts, "-") + ".json"
+}
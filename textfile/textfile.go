@@ -0,0 +1,71 @@
+package textfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_model/go"
+	"ibenc/metrics"
+)
+
+// filePrefix starts the name of every file ibenc writes to the collector directory
+const filePrefix = "ibenc"
+
+// Write stores metrics for the node_exporter textfile collector, one file per target
+// so runs of one target don't remove the series of another
+// Files are replaced atomically, node_exporter never reads a partial file
+func Write(dir string, families []*io_prometheus_client.MetricFamily) error {
+	for name, targetFamilies := range splitByTarget(families) {
+		var buf bytes.Buffer
+		if err := metrics.WriteText(&buf, targetFamilies, false); err != nil {
+			return fmt.Errorf("failed to encode metrics: %w", err)
+		}
+		if err := writeAtomic(filepath.Join(dir, name), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitByTarget groups series by the file of their target label, ibenc.prom without one
+func splitByTarget(families []*io_prometheus_client.MetricFamily) map[string][]*io_prometheus_client.MetricFamily {
+	files := make(map[string][]*io_prometheus_client.MetricFamily)
+
+	for _, mf := range families {
+		byFile := make(map[string]*io_prometheus_client.MetricFamily)
+		for _, m := range mf.Metric {
+			name := filePrefix + ".prom"
+			for _, lp := range m.Label {
+				if lp.GetName() == "target" {
+					name = filePrefix + "-" + lp.GetValue() + ".prom"
+				}
+			}
+
+			part, ok := byFile[name]
+			if !ok {
+				part = &io_prometheus_client.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				byFile[name] = part
+				files[name] = append(files[name], part)
+			}
+			part.Metric = append(part.Metric, m)
+		}
+	}
+
+	return files
+}
+
+// writeAtomic replaces a file through a temporary file in the same directory,
+// which node_exporter ignores as it doesn't end in .prom
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
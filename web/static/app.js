@@ -0,0 +1,294 @@
+"use strict";
+
+// The token is kept by the browser, the daemon only serves these files without it
+let token = localStorage.getItem("ibenc-token") || "";
+
+const $ = (id) => document.getElementById(id);
+
+async function request(path, options = {}) {
+  const response = await fetch(path, {
+    ...options,
+    headers: { ...(options.headers || {}), Authorization: "Bearer " + token },
+  });
+  if (response.status === 401) {
+    signIn();
+    throw new Error("unauthorized");
+  }
+  return response;
+}
+
+function signIn() {
+  $("token-form").hidden = false;
+  $("controls").hidden = true;
+}
+
+$("token-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  token = $("token").value;
+  localStorage.setItem("ibenc-token", token);
+  $("token-form").hidden = true;
+  $("controls").hidden = false;
+  refresh();
+  showRun();
+});
+
+// seriesKey names the series of a record, tests over both address families are kept apart
+function seriesKey(record) {
+  const name = record.target || "default";
+  return record.ip_version ? name + " IPv" + record.ip_version : name;
+}
+
+function formatNumber(value, unit) {
+  return value ? value.toFixed(1) + " " + unit : "-";
+}
+
+function renderLatest(records) {
+  const latest = new Map();
+  for (const record of records) {
+    latest.set(seriesKey(record), record);
+  }
+
+  const tbody = $("latest").querySelector("tbody");
+  tbody.replaceChildren();
+  for (const [key, record] of latest) {
+    const row = tbody.insertRow();
+    const cells = [
+      key,
+      new Date(record.time).toLocaleString(),
+      formatNumber(record.download_mbps, "Mbps"),
+      formatNumber(record.upload_mbps, "Mbps"),
+      formatNumber(record.latency_ms, "ms"),
+      formatNumber(record.jitter_ms, "ms"),
+      record.packet_loss_percent.toFixed(2) + " %",
+      record.success ? "" : record.error || "failed",
+    ];
+    for (const text of cells) {
+      row.insertCell().textContent = text;
+    }
+    // Raw output of the run, older results have no run ID
+    const run = row.insertCell(cells.length - 1);
+    if (record.run_id) {
+      const link = document.createElement("a");
+      link.href = "#run=" + record.run_id;
+      link.textContent = record.run_id.slice(0, 8);
+      run.append(link);
+    }
+    if (!record.success) {
+      row.lastChild.className = "failed";
+    }
+  }
+  if (latest.size === 0) {
+    const cell = tbody.insertRow().insertCell();
+    cell.colSpan = 9;
+    cell.className = "empty";
+    cell.textContent = "No results in this range yet";
+  }
+}
+
+const svgNS = "http://www.w3.org/2000/svg";
+
+function svgElement(name, attributes) {
+  const element = document.createElementNS(svgNS, name);
+  for (const [key, value] of Object.entries(attributes)) {
+    element.setAttribute(key, value);
+  }
+  return element;
+}
+
+// renderChart draws one line per series and metric, failed tests leave a gap
+function renderChart(container, records, metrics, unit) {
+  const width = 1000, height = 240, left = 50, bottom = 20, top = 10;
+  const svg = svgElement("svg", { viewBox: `0 0 ${width} ${height}`, preserveAspectRatio: "none" });
+
+  const times = records.map((r) => new Date(r.time).getTime());
+  const minTime = Math.min(...times), maxTime = Math.max(...times);
+  let maxValue = 0;
+  for (const record of records) {
+    for (const metric of metrics) {
+      maxValue = Math.max(maxValue, record[metric.field]);
+    }
+  }
+  maxValue = maxValue * 1.1 || 1;
+
+  const x = (t) => left + ((t - minTime) / (maxTime - minTime || 1)) * (width - left - 10);
+  const y = (v) => height - bottom - (v / maxValue) * (height - bottom - top);
+
+  // Horizontal grid with value labels
+  for (let i = 0; i <= 4; i++) {
+    const value = (maxValue / 4) * i;
+    svg.append(svgElement("line", { class: "axis", x1: left, x2: width, y1: y(value), y2: y(value) }));
+    const label = svgElement("text", { x: left - 5, y: y(value) + 4, "text-anchor": "end" });
+    label.textContent = value.toFixed(0);
+    svg.append(label);
+  }
+  for (const t of [minTime, maxTime]) {
+    const label = svgElement("text", { x: x(t), y: height - 4, "text-anchor": t === minTime ? "start" : "end" });
+    label.textContent = new Date(t).toLocaleString();
+    svg.append(label);
+  }
+
+  const keys = [...new Set(records.map(seriesKey))];
+  keys.forEach((key, index) => {
+    for (const metric of metrics) {
+      let points = [];
+      const flush = () => {
+        if (points.length > 0) {
+          svg.append(svgElement("polyline", {
+            class: metric.field.split("_")[0],
+            points: points.join(" "),
+            "stroke-dasharray": index === 0 ? "" : `${index * 4} 3`,
+          }));
+        }
+        points = [];
+      };
+      for (const record of records) {
+        if (seriesKey(record) !== key) {
+          continue;
+        }
+        if (!record.success) {
+          flush();
+          continue;
+        }
+        points.push(`${x(new Date(record.time).getTime()).toFixed(1)},${y(record[metric.field]).toFixed(1)}`);
+      }
+      flush();
+    }
+  });
+
+  const legend = document.createElement("div");
+  legend.className = "legend";
+  for (const metric of metrics) {
+    const item = document.createElement("span");
+    item.textContent = metric.label + " (" + unit + ")";
+    item.className = metric.field.split("_")[0];
+    legend.append(item);
+  }
+  if (keys.length > 1) {
+    const item = document.createElement("span");
+    item.textContent = "Series: " + keys.join(", ") + " (solid, then dashed)";
+    legend.append(item);
+  }
+
+  container.replaceChildren(records.length ? svg : emptyChart(), legend);
+}
+
+function emptyChart() {
+  const p = document.createElement("p");
+  p.className = "empty";
+  p.textContent = "No results in this range yet";
+  return p;
+}
+
+async function refresh() {
+  const params = new URLSearchParams({ range: $("range").value, target: $("target").value });
+  try {
+    const records = await (await request("/v1/history?" + params)).json();
+    renderLatest(records);
+    renderChart($("chart-throughput"), records, [
+      { field: "download_mbps", label: "Download" },
+      { field: "upload_mbps", label: "Upload" },
+    ], "Mbps");
+    renderChart($("chart-latency"), records, [
+      { field: "latency_ms", label: "Latency" },
+      { field: "jitter_ms", label: "Jitter" },
+    ], "ms");
+
+    // Targets are offered once they have results
+    const select = $("target");
+    const known = new Set([...select.options].map((o) => o.value));
+    for (const name of new Set(records.map((r) => r.target).filter(Boolean))) {
+      if (!known.has(name)) {
+        select.add(new Option(name, name));
+      }
+    }
+
+    $("config").textContent = await (await request("/v1/config")).text();
+  } catch (error) {
+    console.error(error);
+  }
+}
+
+// showRun shows the raw iperf3 output of the run in the URL, Grafana exemplar links point to #run=<id>
+async function showRun() {
+  const id = new URLSearchParams(location.hash.slice(1)).get("run");
+  $("raw").hidden = !id;
+  if (!id) {
+    return;
+  }
+  $("raw-id").textContent = id;
+  $("raw-output").textContent = "";
+  try {
+    const response = await request("/v1/runs/" + encodeURIComponent(id));
+    $("raw-output").textContent = response.ok ? JSON.stringify(await response.json(), null, 2) : await response.text();
+  } catch (error) {
+    console.error(error);
+  }
+}
+
+// streamEvents reads server-sent events with fetch, EventSource can't send the token
+async function streamEvents(path, onEvent) {
+  const response = await request(path);
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += value;
+    let end;
+    while ((end = buffer.indexOf("\n\n")) >= 0) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+      let name = "message", data = "";
+      for (const line of block.split("\n")) {
+        if (line.startsWith("event: ")) name = line.slice(7);
+        if (line.startsWith("data: ")) data += line.slice(6);
+      }
+      onEvent(name, JSON.parse(data));
+    }
+  }
+}
+
+$("run").addEventListener("click", async () => {
+  const body = $("target").value ? { target: $("target").value } : {};
+  const response = await request("/v1/tests", { method: "POST", body: JSON.stringify(body) });
+  const test = await response.json();
+  if (!response.ok && !test.id) {
+    alert(test.error);
+    return;
+  }
+
+  // A test already running is followed instead
+  $("run").disabled = true;
+  $("progress").hidden = false;
+  $("progress-status").textContent = response.ok ? "Queued" : "Following running test " + test.id;
+  $("progress-log").textContent = "";
+  try {
+    await streamEvents("/v1/tests/" + test.id + "/stream", (name, data) => {
+      if (name === "status") {
+        $("progress-status").textContent = "Running";
+      } else if (name === "interval") {
+        const direction = data.reverse ? "download" : "upload";
+        $("progress-log").textContent += `${direction} ${data.start.toFixed(0)}-${data.end.toFixed(0)}s ${(data.bits_per_second / 1e6).toFixed(1)} Mbps\n`;
+      } else if (name === "result") {
+        $("progress-status").textContent = data.status === "done" ? "Done" : "Failed: " + data.error;
+      }
+    });
+  } finally {
+    $("run").disabled = false;
+    refresh();
+  }
+});
+
+$("range").addEventListener("change", refresh);
+$("target").addEventListener("change", refresh);
+window.addEventListener("hashchange", showRun);
+
+if (token) {
+  refresh();
+  showRun();
+} else {
+  signIn();
+}
+setInterval(refresh, 60000);
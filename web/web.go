@@ -0,0 +1,86 @@
+package web
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"ibenc/api"
+	"ibenc/history"
+)
+
+// maxRange is the longest history the UI may ask for at once
+const maxRange = 90 * 24 * time.Hour
+
+//go:embed static
+var static embed.FS
+
+// Source provides the data shown by the UI
+type Source struct {
+	// History returns the results since a time, of one target or all of them
+	History func(since time.Time, target string) ([]history.Record, error)
+	// Config returns the current configuration as YAML with secrets redacted
+	Config func() ([]byte, error)
+	// Run returns the raw iperf3 output of a run as JSON, history.ErrNotFound for unknown IDs
+	Run func(id string) ([]byte, error)
+}
+
+// Handler serves the UI pages, which need no token, and the data endpoints behind it, which do
+func Handler(token string, src Source) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(files))
+	mux.HandleFunc("GET /v1/history", api.Authorized(token, func(w http.ResponseWriter, r *http.Request) {
+		window := 24 * time.Hour
+		if v := r.URL.Query().Get("range"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > maxRange {
+				http.Error(w, "range must be a duration up to 2160h", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+
+		records, err := src.History(time.Now().Add(-window), r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if records == nil {
+			records = []history.Record{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	}))
+	mux.HandleFunc("GET /v1/config", api.Authorized(token, func(w http.ResponseWriter, r *http.Request) {
+		data, err := src.Config()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(data)
+	}))
+	mux.HandleFunc("GET /v1/runs/{id}", api.Authorized(token, func(w http.ResponseWriter, r *http.Request) {
+		data, err := src.Run(r.PathValue("id"))
+		if errors.Is(err, history.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+
+	return mux
+}